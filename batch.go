@@ -0,0 +1,223 @@
+package hyliocache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	pb "github.com/hylio/hyliocache/hyliocachepb"
+	"sync"
+	"time"
+)
+
+// BatchGetter getter可以选择实现这个接口 GetMany中本地未命中的key会通过一次调用从数据源获取
+// 返回的map中不存在的key视为ErrNotFound 返回error时所有key都失败
+type BatchGetter interface {
+	GetMany(ctx context.Context, keys []string) (map[string][]byte, error)
+}
+
+// GetMany 批量获取缓存 返回获取成功的值和每个失败key的错误 没有失败时errs为nil
+func (g *Group) GetMany(keys []string) (values map[string]ByteView, errs map[string]error) {
+	return g.GetManyContext(context.Background(), keys)
+}
+
+// GetManyContext 批量获取缓存 未命中的key按节点分组 每个远端节点只发送一次BatchGet rpc
+// 本节点负责的key以及远端节点获取失败的key从数据源加载
+func (g *Group) GetManyContext(ctx context.Context, keys []string) (values map[string]ByteView, errs map[string]error) {
+	return g.getMany(ctx, keys, g.peers)
+}
+
+// getManyForPeer 处理其他节点发来的BatchGet 和getForPeer一样不再转发 所有未命中的key从本节点加载
+func (g *Group) getManyForPeer(ctx context.Context, keys []string) (values map[string]ByteView, errs map[string]error) {
+	return g.getMany(ctx, keys, nil)
+}
+
+// getMany 批量获取缓存 peers为nil时不访问远端节点
+func (g *Group) getMany(ctx context.Context, keys []string, peers PeerPicker) (values map[string]ByteView, errs map[string]error) {
+	b := &batch{values: make(map[string]ByteView, len(keys))}
+	var local []string
+	remote := make(map[PeerGetter][]string)
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if key == "" {
+			b.fail(key, fmt.Errorf("key is required"))
+			continue
+		}
+		if v, ok, err := g.lookupCache(key); ok {
+			b.done(key, v, err)
+			continue
+		}
+		if peers != nil {
+			if peer, ok := peers.PickPeer(key); ok {
+				remote[peer] = append(remote[peer], key)
+				continue
+			}
+		}
+		local = append(local, key)
+	}
+
+	var wg sync.WaitGroup
+	for peer, keys := range remote {
+		wg.Add(1)
+		go func(peer PeerGetter, keys []string) {
+			defer wg.Done()
+			if failed := g.getManyFromPeer(ctx, keys, peer, b); len(failed) > 0 {
+				g.getManyLocally(ctx, failed, b)
+			}
+		}(peer, keys)
+	}
+	if len(local) > 0 {
+		g.getManyLocally(ctx, local, b)
+	}
+	wg.Wait()
+	return b.values, b.errs
+}
+
+// batch 收集GetMany的结果 可以被多个goroutine同时写入
+type batch struct {
+	mu     sync.Mutex
+	values map[string]ByteView
+	errs   map[string]error
+}
+
+func (b *batch) done(key string, value ByteView, err error) {
+	if err != nil {
+		b.fail(key, err)
+		return
+	}
+	b.mu.Lock()
+	b.values[key] = value
+	b.mu.Unlock()
+}
+
+func (b *batch) fail(key string, err error) {
+	b.mu.Lock()
+	if b.errs == nil {
+		b.errs = make(map[string]error)
+	}
+	b.errs[key] = err
+	b.mu.Unlock()
+}
+
+// getManyFromPeer 通过一次rpc从远端节点获取多个key 返回需要回退到数据源的key
+func (g *Group) getManyFromPeer(ctx context.Context, keys []string, peer PeerGetter, b *batch) (failed []string) {
+	resp, err := peer.BatchGet(ctx, &pb.BatchRequest{Group: g.name, Keys: keys})
+	if err != nil {
+		if err := g.peerFailed(ctx, err); err != nil {
+			for _, key := range keys {
+				b.fail(key, err)
+			}
+			return nil
+		}
+		return keys
+	}
+	got := make(map[string]bool, len(keys))
+	for _, r := range resp.GetResults() {
+		key := r.GetKey()
+		got[key] = true
+		switch {
+		case r.GetNotFound():
+			b.fail(key, ErrNotFound)
+		case r.GetError() != "":
+			// 和Get一样 远端节点加载失败的key回退到本地加载
+			if err := g.peerFailed(ctx, errors.New(r.GetError())); err != nil {
+				b.fail(key, err)
+				continue
+			}
+			failed = append(failed, key)
+		default:
+			value := ByteView{b: r.GetValue()}
+			g.populateHotCache(key, value)
+			b.done(key, value, nil)
+		}
+	}
+	// 远端节点没有返回结果的key
+	for _, key := range keys {
+		if !got[key] {
+			failed = append(failed, key)
+		}
+	}
+	return failed
+}
+
+// batchLoadConcurrency getter没有实现BatchGetter时 GetMany同时加载的key的最大个数
+const batchLoadConcurrency = 8
+
+// getManyLocally 从数据源加载多个key getter实现了BatchGetter时只调用一次
+// 否则每个key分别通过localLoader加载 已经在localLoader中加载的key等待进行中的请求
+func (g *Group) getManyLocally(ctx context.Context, keys []string, b *batch) {
+	getter, ok := g.getter.(BatchGetter)
+	if adapter, isAdapter := g.getter.(getterAdapter); isAdapter {
+		getter, ok = adapter.Getter.(BatchGetter)
+	}
+	if !ok {
+		g.loadEach(ctx, keys, b)
+		return
+	}
+
+	// 已经在加载的key等待进行中的请求 与批量加载同时进行
+	var loading, pending []string
+	for _, key := range keys {
+		if g.localLoader.Loading(key) {
+			loading = append(loading, key)
+		} else {
+			pending = append(pending, key)
+		}
+	}
+	if len(loading) > 0 {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.loadEach(ctx, loading, b)
+		}()
+		defer wg.Wait()
+	}
+	if len(pending) == 0 {
+		return
+	}
+
+	found, err := getter.GetMany(ctx, pending)
+	if err != nil {
+		for _, key := range pending {
+			b.fail(key, err)
+		}
+		return
+	}
+	for _, key := range pending {
+		bytes, ok := found[key]
+		if !ok {
+			if g.negCache != nil {
+				g.negCache.add(key, ByteView{}, time.Time{}, time.Now().Add(g.negTTL))
+			}
+			b.fail(key, ErrNotFound)
+			continue
+		}
+		// 返回一个深拷贝 而不是源数据
+		value := ByteView{b: cloneBytes(bytes)}
+		g.populateLoaded(key, value)
+		b.done(key, value, nil)
+	}
+}
+
+// loadEach 每个key分别通过localLoader加载 最多同时加载batchLoadConcurrency个
+func (g *Group) loadEach(ctx context.Context, keys []string, b *batch) {
+	sem := make(chan struct{}, batchLoadConcurrency)
+	var wg sync.WaitGroup
+	for _, key := range keys {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(key string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			view, err := g.loadLocally(ctx, key)
+			b.done(key, view, err)
+		}(key)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,172 @@
+package hyliocache
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// batchGetter 同时实现Getter和BatchGetter 记录每次批量加载的key
+type batchGetter struct {
+	batches [][]string
+}
+
+func (g *batchGetter) Get(key string) ([]byte, error) {
+	return nil, errors.New("should use GetMany")
+}
+
+func (g *batchGetter) GetMany(ctx context.Context, keys []string) (map[string][]byte, error) {
+	sorted := append([]string(nil), keys...)
+	sort.Strings(sorted)
+	g.batches = append(g.batches, sorted)
+	values := make(map[string][]byte)
+	for _, key := range keys {
+		if v, ok := db[key]; ok {
+			values[key] = []byte(v)
+		}
+	}
+	return values, nil
+}
+
+func TestGetMany(t *testing.T) {
+	getter := &batchGetter{}
+	c := NewGroup("batch_name", 2<<10, getter)
+	peer := &fakePeer{}
+	c.RegisterPeers(&fakePicker{peer: peer, remote: map[string]bool{"wangrui": true, "remote_unknown": true}})
+
+	keys := []string{"zhanghao", "zhouruqiang", "unknown", "wangrui", "remote_unknown", "zhanghao", ""}
+	values, errs := c.GetMany(keys)
+	expect := map[string]string{"zhanghao": "hylio", "zhouruqiang": "dio", "wangrui": "peer:wangrui"}
+	if len(values) != len(expect) {
+		t.Fatalf("expect %d values, got %d", len(expect), len(values))
+	}
+	for key, v := range expect {
+		if values[key].String() != v {
+			t.Fatalf("expect %s=%s, got %s", key, v, values[key])
+		}
+	}
+	if len(errs) != 3 || !errors.Is(errs["unknown"], ErrNotFound) || !errors.Is(errs["remote_unknown"], ErrNotFound) || errs[""] == nil {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+	// 每个节点只访问一次
+	if !reflect.DeepEqual(peer.batches, [][]string{{"wangrui", "remote_unknown"}}) || peer.gets != 0 {
+		t.Fatalf("remote keys should be fetched in one batch, got %v", peer.batches)
+	}
+	if !reflect.DeepEqual(getter.batches, [][]string{{"unknown", "zhanghao", "zhouruqiang"}}) {
+		t.Fatalf("local keys should be loaded in one batch, got %v", getter.batches)
+	}
+
+	// 已经缓存的key不再加载
+	if values, errs := c.GetMany([]string{"zhanghao", "zhouruqiang"}); errs != nil || len(values) != 2 || len(getter.batches) != 1 {
+		t.Fatal("cached keys should not be loaded again")
+	}
+}
+
+func TestGetManyWithoutBatchGetter(t *testing.T) {
+	c := NewGroup("batch_single_name", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}))
+	values, errs := c.GetMany([]string{"zhanghao", "wangrui"})
+	if errs != nil || values["zhanghao"].String() != "hylio" || values["wangrui"].String() != "civet" {
+		t.Fatalf("keys should be loaded one by one, got %v %v", values, errs)
+	}
+}
+
+func TestGetManyConcurrency(t *testing.T) {
+	var running, max int32
+	c := NewGroup("batch_concurrency_name", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return []byte(key), nil
+	}))
+	keys := make([]string, 4*batchLoadConcurrency)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+	values, errs := c.GetMany(keys)
+	if errs != nil || len(values) != len(keys) {
+		t.Fatalf("all keys should be loaded, got %d values %v", len(values), errs)
+	}
+	if max > batchLoadConcurrency {
+		t.Fatalf("at most %d keys should be loaded at once, got %d", batchLoadConcurrency, max)
+	}
+}
+
+// blockingBatchGetter Get阻塞到release关闭 GetMany记录每次批量加载的key
+type blockingBatchGetter struct {
+	batchGetter
+	started chan struct{}
+	release chan struct{}
+	gets    int32
+}
+
+func (g *blockingBatchGetter) Get(key string) ([]byte, error) {
+	atomic.AddInt32(&g.gets, 1)
+	close(g.started)
+	<-g.release
+	return []byte(db[key]), nil
+}
+
+func TestGetManyWaitsForLoading(t *testing.T) {
+	getter := &blockingBatchGetter{started: make(chan struct{}), release: make(chan struct{})}
+	c := NewGroup("batch_loading_name", 2<<10, getter)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if v, err := c.Get("zhanghao"); err != nil || v.String() != "hylio" {
+			t.Errorf("get zhanghao failed: %v %v", v, err)
+		}
+	}()
+	<-getter.started
+
+	done := make(chan struct{})
+	var values map[string]ByteView
+	var errs map[string]error
+	go func() {
+		values, errs = c.GetMany([]string{"zhanghao", "zhouruqiang"})
+		close(done)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(getter.release)
+	<-done
+	wg.Wait()
+
+	if errs != nil || values["zhanghao"].String() != "hylio" || values["zhouruqiang"].String() != "dio" {
+		t.Fatalf("unexpected result %v %v", values, errs)
+	}
+	// 进行中的key不再进入批量加载
+	if !reflect.DeepEqual(getter.batches, [][]string{{"zhouruqiang"}}) || getter.gets != 1 {
+		t.Fatalf("loading key should wait for the in-flight load, got batches %v gets %d", getter.batches, getter.gets)
+	}
+}
+
+func TestGetManyPeerError(t *testing.T) {
+	getter := &batchGetter{}
+	c := NewGroup("batch_peer_error_name", 2<<10, getter)
+	peer := &fakePeer{failing: map[string]bool{"zhouruqiang": true}}
+	c.RegisterPeers(&fakePicker{peer: peer, remote: map[string]bool{"wangrui": true, "zhouruqiang": true}})
+
+	// 远端节点加载失败的key回退到本地加载
+	values, errs := c.GetMany([]string{"wangrui", "zhouruqiang"})
+	if errs != nil || values["wangrui"].String() != "peer:wangrui" || values["zhouruqiang"].String() != "dio" {
+		t.Fatalf("failed keys should be loaded locally, got %v %v", values, errs)
+	}
+	if !reflect.DeepEqual(getter.batches, [][]string{{"zhouruqiang"}}) {
+		t.Fatalf("only failed keys should be loaded locally, got %v", getter.batches)
+	}
+}
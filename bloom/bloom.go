@@ -0,0 +1,125 @@
+package bloom
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math"
+	"sync"
+)
+
+/*
+	布隆过滤器 用于判断key是否一定不存在
+	Test返回false时key一定没有被Add过 返回true时key可能存在
+	k个哈希位置由两个哈希值组合得到(Kirsch-Mitzenmacher)
+	过滤器可以序列化 方便在节点之间共享
+*/
+
+const version = 1
+
+// headerLen 序列化头部 版本(1字节) + k(4字节) + m(8字节)
+const headerLen = 1 + 4 + 8
+
+var errInvalidData = errors.New("bloom: invalid data")
+
+type Filter struct {
+	mu   sync.RWMutex
+	bits []uint64
+	m    uint64 // 位数
+	k    uint32 // 哈希函数个数
+}
+
+// New 根据预计元素个数n和期望的误判率fp创建过滤器
+func New(n uint64, fp float64) *Filter {
+	if n == 0 {
+		n = 1
+	}
+	if fp <= 0 || fp >= 1 {
+		fp = 0.01
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(fp) / (math.Ln2 * math.Ln2)))
+	k := uint32(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return newFilter(m, k)
+}
+
+func newFilter(m uint64, k uint32) *Filter {
+	// 按64位对齐
+	m = (m + 63) / 64 * 64
+	return &Filter{
+		bits: make([]uint64, m/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+func hash(key string) (uint64, uint64) {
+	h1 := fnv.New64a()
+	h1.Write([]byte(key))
+	h2 := fnv.New64()
+	h2.Write([]byte(key))
+	return h1.Sum64(), h2.Sum64() | 1
+}
+
+// Add 添加key
+func (f *Filter) Add(keys ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, key := range keys {
+		h1, h2 := hash(key)
+		for i := uint32(0); i < f.k; i++ {
+			pos := (h1 + uint64(i)*h2) % f.m
+			f.bits[pos/64] |= 1 << (pos % 64)
+		}
+	}
+}
+
+// Test 判断key是否可能存在 返回false表示一定不存在
+func (f *Filter) Test(key string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	h1, h2 := hash(key)
+	for i := uint32(0); i < f.k; i++ {
+		pos := (h1 + uint64(i)*h2) % f.m
+		if f.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// MarshalBinary 序列化过滤器
+func (f *Filter) MarshalBinary() ([]byte, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	data := make([]byte, headerLen+8*len(f.bits))
+	data[0] = version
+	binary.BigEndian.PutUint32(data[1:], f.k)
+	binary.BigEndian.PutUint64(data[5:], f.m)
+	for i, b := range f.bits {
+		binary.BigEndian.PutUint64(data[headerLen+8*i:], b)
+	}
+	return data, nil
+}
+
+// UnmarshalBinary 用序列化的数据替换过滤器的内容 可以在使用中安全地调用
+func (f *Filter) UnmarshalBinary(data []byte) error {
+	if len(data) < headerLen || data[0] != version {
+		return errInvalidData
+	}
+	k := binary.BigEndian.Uint32(data[1:])
+	m := binary.BigEndian.Uint64(data[5:])
+	if k == 0 || m == 0 || m%64 != 0 || uint64(len(data)-headerLen) != m/8 {
+		return errInvalidData
+	}
+	bits := make([]uint64, m/64)
+	for i := range bits {
+		bits[i] = binary.BigEndian.Uint64(data[headerLen+8*i:])
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.bits, f.m, f.k = bits, m, k
+	return nil
+}
@@ -0,0 +1,47 @@
+package bloom
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	f := New(1000, 0.01)
+	for i := 0; i < 1000; i++ {
+		f.Add("key" + strconv.Itoa(i))
+	}
+	for i := 0; i < 1000; i++ {
+		if !f.Test("key" + strconv.Itoa(i)) {
+			t.Fatalf("key%d should exist", i)
+		}
+	}
+	// 误判率应该接近1%
+	fp := 0
+	for i := 0; i < 10000; i++ {
+		if f.Test("other" + strconv.Itoa(i)) {
+			fp++
+		}
+	}
+	if fp > 300 {
+		t.Fatalf("false positive rate too high: %d/10000", fp)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	f := New(100, 0.01)
+	f.Add("name", "age")
+	data, err := f.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := New(1, 0.5)
+	if err := g.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !g.Test("name") || !g.Test("age") || g.m != f.m || g.k != f.k {
+		t.Fatal("unmarshal filter fail")
+	}
+	if err := g.UnmarshalBinary(data[:len(data)-1]); err == nil {
+		t.Fatal("truncated data should fail")
+	}
+}
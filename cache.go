@@ -6,36 +6,176 @@ cache 模块负责提供缓存的并发控制
 
 import (
 	"github.com/hylio/hyliocache/lru"
+	"log"
 	"sync"
+	"time"
 )
 
 // 把算法和实际缓存进行了分离
-// 这样如果要更换算法 就只需要替换lru
+// 淘汰算法由lru.Policy接口抽象 通过newPolicy选择
+// 缓存按key的哈希分成多个分片 每个分片独立加锁 减少并发访问时的锁竞争
+
+const defaultShards = 1
+
+// CacheStats 缓存的统计信息 多个分片时为所有分片之和
+type CacheStats struct {
+	Bytes     int64 // 已使用内存
+	Items     int64 // 元素个数
+	Gets      int64 // 查询次数
+	Hits      int64 // 命中次数
+	Evictions int64 // 淘汰次数 不包括Remove等主动删除
+	TooLarge  int64 // 超过分片容量而没有写入缓存的次数
+}
 
 type cache struct {
+	shards     []*cacheShard
+	nshards    int           // 分片个数
+	newPolicy  lru.NewPolicy // 淘汰策略的构造函数 为nil时使用lru
+	cacheBytes int64         // 最大缓存容量 平均分给每个分片
+}
+
+// entry 缓存中实际保存的值 记录数据的写入时间和何时变旧
+type entry struct {
+	value   ByteView
+	created time.Time // 写入缓存的时间
+	staleAt time.Time // 在此之后数据变旧 需要刷新 零值表示永不变旧
+}
+
+func (e entry) Len() int {
+	return e.value.Len()
+}
+
+// stale 判断数据在now时刻是否已经变旧
+func (e entry) stale(now time.Time) bool {
+	return !e.staleAt.IsZero() && now.After(e.staleAt)
+}
+
+// refreshDue 判断数据在now时刻是否已经进入有效期的最后ratio部分 需要提前刷新
+func (e entry) refreshDue(now time.Time, ratio float64) bool {
+	if e.staleAt.IsZero() {
+		return false
+	}
+	ahead := time.Duration(float64(e.staleAt.Sub(e.created)) * ratio)
+	return now.After(e.staleAt.Add(-ahead))
+}
+
+// cacheShard 单个分片 一把锁保护一个淘汰策略
+type cacheShard struct {
 	mu         sync.Mutex
-	lru        *lru.Cache
-	cacheBytes int64 // 最大缓存容量
+	policy     lru.Policy
+	newPolicy  lru.NewPolicy
+	cacheBytes int64
+	nget       int64
+	nhit       int64
+	nevict     int64
+	ntoolarge  int64
 }
 
-func (c *cache) add(key string, value ByteView) {
+// init 按配置创建分片 需要在使用前调用
+func (c *cache) init() {
+	if c.nshards <= 0 {
+		c.nshards = defaultShards
+	}
+	if c.newPolicy == nil {
+		c.newPolicy = lru.LRU
+	}
+	shardBytes := c.cacheBytes / int64(c.nshards)
+	if c.cacheBytes > 0 && shardBytes == 0 {
+		// 避免容量为0被当作不限制内存
+		shardBytes = 1
+	}
+	c.shards = make([]*cacheShard, c.nshards)
+	for i := range c.shards {
+		c.shards[i] = &cacheShard{newPolicy: c.newPolicy, cacheBytes: shardBytes}
+	}
+}
+
+// shard 根据key的fnv-1a哈希选择分片
+func (c *cache) shard(key string) *cacheShard {
+	if len(c.shards) == 1 {
+		return c.shards[0]
+	}
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return c.shards[h%uint32(len(c.shards))]
+}
+
+// add 添加缓存 staleAt之后数据变旧 expire之后数据被删除 零值表示永不变旧/过期
+func (c *cache) add(key string, value ByteView, staleAt, expire time.Time) {
+	c.shard(key).add(key, entry{value: value, created: time.Now(), staleAt: staleAt}, expire)
+}
+
+func (c *cache) get(key string) (value ByteView, ok bool) {
+	e, ok := c.lookup(key)
+	return e.value, ok
+}
+
+// lookup 查找缓存 同时返回数据何时变旧
+func (c *cache) lookup(key string) (entry, bool) {
+	return c.shard(key).get(key)
+}
+
+// remove 删除缓存 返回缓存是否存在
+func (c *cache) remove(key string) bool {
+	return c.shard(key).remove(key)
+}
+
+// stats 汇总所有分片的统计信息
+func (c *cache) stats() CacheStats {
+	var s CacheStats
+	for _, shard := range c.shards {
+		shard.mu.Lock()
+		if shard.policy != nil {
+			s.Bytes += shard.policy.Bytes()
+			s.Items += int64(shard.policy.Len())
+		}
+		s.Gets += shard.nget
+		s.Hits += shard.nhit
+		s.Evictions += shard.nevict
+		s.TooLarge += shard.ntoolarge
+		shard.mu.Unlock()
+	}
+	return s
+}
+
+func (c *cacheShard) add(key string, value entry, expire time.Time) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if c.lru == nil {
+	if c.policy == nil {
 		// 懒初始化  在第一次使用时再初始化
-		c.lru = lru.New(c.cacheBytes, nil)
+		c.policy = c.newPolicy(c.cacheBytes, nil)
+	}
+	// 只统计写入时的淘汰 Delete等主动删除不算
+	evicted, err := c.policy.AddWithExpire(key, value, expire)
+	c.nevict += int64(len(evicted))
+	if err != nil {
+		c.ntoolarge++
+		log.Printf("[hylioCache] %s (%d bytes) is larger than the shard capacity %d bytes, not cached", key, value.Len(), c.cacheBytes)
 	}
-	c.lru.Add(key, value)
 }
 
-func (c *cache) get(key string) (value ByteView, ok bool) {
+func (c *cacheShard) get(key string) (value entry, ok bool) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if c.lru == nil {
+	c.nget++
+	if c.policy == nil {
 		return
 	}
-	if v, ok := c.lru.Get(key); ok {
-		return v.(ByteView), ok
+	if v, ok := c.policy.Get(key); ok {
+		c.nhit++
+		return v.(entry), ok
 	}
 	return
 }
+
+func (c *cacheShard) remove(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.policy == nil {
+		return false
+	}
+	return c.policy.Delete(key)
+}
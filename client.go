@@ -11,51 +11,230 @@ package hyliocache
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	pb "github.com/hylio/hyliocache/hyliocachepb"
 	"github.com/hylio/hyliocache/registry"
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"io"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // client 实现了访问其他远程节点并获取缓存的能力
 
+// defaultTimeout 调用方没有设置超时时 rpc的默认超时时间
+const defaultTimeout = 10 * time.Second
+
+var errClientClosed = errors.New("hyliocache: client is closed")
+
 type Client struct {
 	addr string // 定义将要访问的服务的地址 ip:port
+	opts ClientOptions
+	// etcd 返回共享的etcd client 由Server创建Client时设置 为nil时Client自己创建并负责关闭
+	etcd    func() (*clientv3.Client, error)
+	mu      sync.Mutex
+	conn    *grpc.ClientConn // 懒创建的长连接
+	ownEtcd *clientv3.Client // Client自己创建的etcd client
+	closed  bool
+	// inflight 正在进行的rpc个数 用于有界负载的一致性哈希
+	inflight int64
+	// total 由Server创建Client时设置 所有Client共享 记录发往所有节点的进行中rpc个数
+	total *int64
+}
+
+// begin 记录一个进行中的rpc 返回的函数在rpc结束时调用
+func (c *Client) begin() func() {
+	c.add(1)
+	return func() {
+		c.add(-1)
+	}
+}
+
+func (c *Client) add(delta int64) {
+	atomic.AddInt64(&c.inflight, delta)
+	if c.total != nil {
+		atomic.AddInt64(c.total, delta)
+	}
 }
 
-func (c *Client) Get(in *pb.Request) ([]byte, error) {
-	// 创建一个etcd client
-	cli, err := clientv3.New(defaultEtcdConfig)
+// withTimeout 沿用调用方的ctx 调用方没有设置超时时加上默认超时 防止rpc无限期阻塞
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, defaultTimeout)
+}
+
+// getConn 返回grpc连接 第一次调用或者连接已经关闭时通过etcd发现服务并重新建立连接
+// 单次rpc失败不关闭连接 grpc会自动重连 关闭共享的连接会取消其他进行中的rpc
+func (c *Client) getConn() (*grpc.ClientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil, errClientClosed
+	}
+	if c.conn != nil && c.conn.GetState() != connectivity.Shutdown {
+		return c.conn, nil
+	}
+	cli, err := c.etcdClient()
 	if err != nil {
 		return nil, err
 	}
-	defer cli.Close()
 	// 发现服务
-	conn, err := registry.EtcdDial(cli, "_hyliocache" + "/" + c.addr)
+	conn, err := registry.EtcdDial(cli, c.opts.ServiceName+"/"+c.addr, grpc.WithDefaultCallOptions(
+		grpc.MaxCallRecvMsgSize(c.opts.MaxMsgSize),
+		grpc.MaxCallSendMsgSize(c.opts.MaxMsgSize),
+	))
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	return conn, nil
+}
+
+// etcdClient 优先使用共享的etcd client 调用时需要持有c.mu
+func (c *Client) etcdClient() (*clientv3.Client, error) {
+	if c.etcd != nil {
+		return c.etcd()
+	}
+	if c.ownEtcd == nil {
+		cli, err := clientv3.New(c.opts.Etcd.config())
+		if err != nil {
+			return nil, err
+		}
+		c.ownEtcd = cli
+	}
+	return c.ownEtcd, nil
+}
+
+// Close 关闭grpc连接 以及Client自己创建的etcd client 之后的调用都会返回错误
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	var err error
+	if c.conn != nil {
+		err = c.conn.Close()
+		c.conn = nil
+	}
+	if c.ownEtcd != nil {
+		if cerr := c.ownEtcd.Close(); err == nil {
+			err = cerr
+		}
+		c.ownEtcd = nil
+	}
+	return err
+}
+
+// Get 通过GetStream获取远端节点上的缓存 并把所有Chunk拼接起来
+func (c *Client) Get(ctx context.Context, in *pb.Request) ([]byte, error) {
+	r, err := c.GetStream(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	bytes, err := io.ReadAll(r)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("can not get %s/%s from peer %s: %v", in.GetGroup(), in.GetKey(), c.addr, err)
+	}
+	return bytes, nil
+}
+
+// GetStream 以流的方式读取远端节点上的缓存 大value不需要一次性放进一个grpc消息
+// 数据不存在时Read返回ErrNotFound 使用完毕后需要调用Close
+func (c *Client) GetStream(ctx context.Context, in *pb.Request) (io.ReadCloser, error) {
+	done := c.begin()
+	conn, err := c.getConn()
 	if err != nil {
+		done()
 		return nil, err
 	}
-	defer conn.Close()
 	grpcClient := pb.NewGroupCacheClient(conn)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	ctx, cancel := withTimeout(ctx)
+	stream, err := grpcClient.GetStream(ctx, &pb.Request{
+		Group: in.GetGroup(),
+		Key:   in.GetKey(),
+	})
+	if err != nil {
+		cancel()
+		done()
+		return nil, fmt.Errorf("can not get %s/%s from peer %s: %v", in.GetGroup(), in.GetKey(), c.addr, err)
+	}
+	return &streamReader{recv: stream.Recv, close: func() {
+		cancel()
+		done()
+	}}, nil
+}
+
+// Delete 删除远端节点上的缓存
+func (c *Client) Delete(ctx context.Context, in *pb.Request) error {
+	defer c.begin()()
+	conn, err := c.getConn()
+	if err != nil {
+		return err
+	}
+	grpcClient := pb.NewGroupCacheClient(conn)
+	ctx, cancel := withTimeout(ctx)
 	defer cancel()
 	group, key := in.GetGroup(), in.GetKey()
-	resp, err := grpcClient.Get(ctx, &pb.Request{
+	_, err = grpcClient.Delete(ctx, &pb.Request{
 		Group: group,
 		Key:   key,
 	})
+	if err != nil {
+		return fmt.Errorf("can not delete %s/%s from peer %s: %v", group, key, c.addr, err)
+	}
+	return nil
+}
 
+// Set 把缓存写入远端节点
+func (c *Client) Set(ctx context.Context, in *pb.SetRequest) error {
+	defer c.begin()()
+	conn, err := c.getConn()
 	if err != nil {
-		return nil, fmt.Errorf("can not get %s/%s from peer %s", group, key, c.addr)
+		return err
 	}
-	bytes := resp.GetValue()
+	grpcClient := pb.NewGroupCacheClient(conn)
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	_, err = grpcClient.Set(ctx, in)
+	if err != nil {
+		return fmt.Errorf("can not set %s/%s to peer %s: %v", in.GetGroup(), in.GetKey(), c.addr, err)
+	}
+	return nil
+}
 
-	return bytes, nil
+// BatchGet 通过一次rpc从远端节点获取多个key
+func (c *Client) BatchGet(ctx context.Context, in *pb.BatchRequest) (*pb.BatchResponse, error) {
+	defer c.begin()()
+	conn, err := c.getConn()
+	if err != nil {
+		return nil, err
+	}
+	grpcClient := pb.NewGroupCacheClient(conn)
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	resp, err := grpcClient.BatchGet(ctx, in)
+	if err != nil {
+		return nil, fmt.Errorf("can not batch get %d keys of %s from peer %s: %v", len(in.GetKeys()), in.GetGroup(), c.addr, err)
+	}
+	return resp, nil
 }
 
 func NewClient(addr string) *Client {
-	return &Client{addr: addr}
+	return NewClientWithOptions(addr, ClientOptions{})
+}
+
+// NewClientWithOptions 使用指定的etcd配置和服务名创建Client
+func NewClientWithOptions(addr string, opts ClientOptions) *Client {
+	return &Client{addr: addr, opts: opts.withDefaults()}
 }
 
 // 测试Client是否实现了PeerGetter接口
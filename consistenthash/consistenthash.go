@@ -2,6 +2,7 @@ package consistenthash
 
 import (
 	"hash/crc32"
+	"math"
 	"sort"
 	"strconv"
 )
@@ -14,27 +15,140 @@ type Map struct {
 	replicas int            // 虚拟节点倍数
 	keys     []int          // 哈希环
 	hashMap  map[int]string // 节点与虚拟节点的对应关系
+	nodes    map[string]int // 环上的所有节点和它们的权重
+	weight   int            // 所有节点的权重之和
+	// 有界负载 节点的负载超过(1+epsilon)倍按权重分摊的平均负载时 沿哈希环选择下一个节点
+	epsilon float64
+	load    func(node string) int64 // 为nil时不启用有界负载
+	total   func() int64            // 所有节点的负载之和
 }
 
+// Add 添加权重为1的节点 已经在环上的节点会被忽略
 func (m *Map) Add(keys ...string) {
 	for _, key := range keys {
-		for i := 0; i < m.replicas; i++ {
-			//计算节点哈希值
-			hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
-			m.keys = append(m.keys, hash)
-			m.hashMap[hash] = key
+		if _, ok := m.nodes[key]; !ok {
+			m.add(key, 1)
 		}
 	}
 	sort.Ints(m.keys)
 }
 
-// GetPeer 获取key应该存放的cache
+// AddWeighted 添加节点 虚拟节点个数为replicas*weight 内存更大的节点可以设置更大的权重
+// 节点已经在环上时更新它的权重 weight小于1时视为1
+func (m *Map) AddWeighted(node string, weight int) {
+	if weight < 1 {
+		weight = 1
+	}
+	if w, ok := m.nodes[node]; ok {
+		if w == weight {
+			return
+		}
+		m.Remove(node)
+	}
+	m.add(node, weight)
+	sort.Ints(m.keys)
+}
+
+// add 添加节点的虚拟节点 调用方负责排序
+func (m *Map) add(node string, weight int) {
+	m.nodes[node] = weight
+	m.weight += weight
+	for i := 0; i < m.replicas*weight; i++ {
+		//计算节点哈希值
+		hash := int(m.hash([]byte(strconv.Itoa(i) + node)))
+		m.keys = append(m.keys, hash)
+		m.hashMap[hash] = node
+	}
+}
+
+// Remove 删除节点和它的所有虚拟节点 其他节点上的key不受影响
+func (m *Map) Remove(nodes ...string) {
+	removed := false
+	for _, node := range nodes {
+		weight, ok := m.nodes[node]
+		if !ok {
+			continue
+		}
+		delete(m.nodes, node)
+		m.weight -= weight
+		removed = true
+		for i := 0; i < m.replicas*weight; i++ {
+			hash := int(m.hash([]byte(strconv.Itoa(i) + node)))
+			// 哈希冲突时虚拟节点可能属于其他节点
+			if m.hashMap[hash] == node {
+				delete(m.hashMap, hash)
+			}
+		}
+	}
+	if !removed {
+		return
+	}
+	keys := m.keys[:0]
+	for _, hash := range m.keys {
+		if _, ok := m.hashMap[hash]; ok {
+			keys = append(keys, hash)
+		}
+	}
+	m.keys = keys
+}
+
+// Members 按字典序返回环上的所有节点
+func (m *Map) Members() []string {
+	members := make([]string, 0, len(m.nodes))
+	for node := range m.nodes {
+		members = append(members, node)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// SetLoad 启用有界负载的一致性哈希(Mirrokni et al.) load返回节点当前的负载 例如正在处理的请求数
+// total返回所有节点的负载之和 由调用方维护 GetPeer不必每次遍历所有节点
+// key对应的节点负载超过(1+epsilon)倍平均负载时 GetPeer沿哈希环选择下一个没有超过上限的节点
+// 平均负载按节点的权重分摊 epsilon<=0或者load、total为nil时不启用
+func (m *Map) SetLoad(epsilon float64, load func(node string) int64, total func() int64) {
+	m.epsilon = epsilon
+	m.load = load
+	m.total = total
+}
+
+// GetPeer 获取处理key的节点 启用有界负载时可能不是key所在的节点
 func (m *Map) GetPeer(key string) string {
 	if len(m.keys) == 0 {
 		return ""
 	}
-	hash := int(m.hash([]byte(key)))
-	idx := sort.SearchInts(m.keys, hash)
+	idx := m.search(key)
+	if m.load == nil || m.total == nil || m.epsilon <= 0 {
+		return m.hashMap[m.keys[idx%len(m.keys)]]
+	}
+	return m.boundedPeer(idx)
+}
+
+// Owner 获取key所在的节点 不考虑负载
+func (m *Map) Owner(key string) string {
+	if len(m.keys) == 0 {
+		return ""
+	}
+	return m.hashMap[m.keys[m.search(key)%len(m.keys)]]
+}
+
+// search 返回哈希环上第一个不小于key哈希值的位置 可能等于len(m.keys)
+func (m *Map) search(key string) int {
+	return sort.SearchInts(m.keys, int(m.hash([]byte(key))))
+}
+
+// boundedPeer 从idx开始沿哈希环找到第一个加上本次请求后负载不超过上限的节点
+// 只读取经过的节点的负载
+func (m *Map) boundedPeer(idx int) string {
+	// 每单位权重的负载上限
+	bound := float64(m.total()+1) / float64(m.weight) * (1 + m.epsilon)
+	for i := 0; i < len(m.keys); i++ {
+		node := m.hashMap[m.keys[(idx+i)%len(m.keys)]]
+		if float64(m.load(node)+1) <= math.Ceil(bound*float64(m.nodes[node])) {
+			return node
+		}
+	}
+	// 上限向上取整 至少有一个节点满足 这里只是兜底
 	return m.hashMap[m.keys[idx%len(m.keys)]]
 }
 
@@ -43,6 +157,7 @@ func New(replicas int, hash Hash) *Map {
 		replicas: replicas,
 		hash:     hash,
 		hashMap:  make(map[int]string),
+		nodes:    make(map[string]int),
 	}
 	if m.hash == nil {
 		// 默认哈希函数
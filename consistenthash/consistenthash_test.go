@@ -1,6 +1,7 @@
 package consistenthash
 
 import (
+	"reflect"
 	"strconv"
 	"testing"
 )
@@ -35,3 +36,114 @@ func TestHash(t *testing.T) {
 		}
 	}
 }
+
+func TestRemove(t *testing.T) {
+	hashfn := func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	}
+
+	m := New(3, hashfn)
+	m.Add("2", "5", "8") // keys = [2 5 8 12 15 18 22 25 28]
+	m.Remove("5")        // keys = [2 8 12 18 22 28]
+	testcases := map[string]string{
+		"2":  "2",
+		"4":  "8",
+		"11": "2",
+		"13": "8",
+		"23": "8",
+		"29": "2",
+	}
+	for k, v := range testcases {
+		if m.GetPeer(k) != v {
+			t.Errorf("hashing %s, want %s, but got %s", k, v, m.GetPeer(k))
+		}
+	}
+	if !reflect.DeepEqual(m.Members(), []string{"2", "8"}) {
+		t.Errorf("unexpected members %v", m.Members())
+	}
+
+	// 删除不存在的节点 重复添加已有的节点 都不影响哈希环
+	m.Remove("5", "7")
+	m.Add("2")
+	if len(m.keys) != 6 {
+		t.Errorf("ring should have 6 points, got %v", m.keys)
+	}
+	m.Remove("2", "8")
+	if m.GetPeer("2") != "" || len(m.Members()) != 0 {
+		t.Error("empty ring should not pick any peer")
+	}
+}
+
+func TestAddWeighted(t *testing.T) {
+	m := New(50, nil)
+	m.Add("small")
+	m.AddWeighted("large", 4)
+	if len(m.keys) != 250 {
+		t.Fatalf("ring should have 250 points, got %d", len(m.keys))
+	}
+	count := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		count[m.GetPeer(strconv.Itoa(i))]++
+	}
+	// 权重为4的节点大约负责80%的key
+	if count["large"] < 7000 || count["large"] > 9000 {
+		t.Errorf("large node should own about 80%% of keys, got %v", count)
+	}
+
+	// 修改权重
+	m.AddWeighted("large", 1)
+	if len(m.keys) != 100 || m.weight != 2 {
+		t.Fatalf("ring should have 100 points after reweighting, got %d", len(m.keys))
+	}
+	m.Remove("large")
+	if len(m.keys) != 50 || m.weight != 1 || !reflect.DeepEqual(m.Members(), []string{"small"}) {
+		t.Fatalf("only small node should be left, got %v", m.Members())
+	}
+}
+
+func TestBoundedLoad(t *testing.T) {
+	hashfn := func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	}
+
+	m := New(3, hashfn)
+	m.Add("2", "5", "8") // keys = [2 5 8 12 15 18 22 25 28]
+	loads := map[string]int64{"2": 0, "5": 0, "8": 0}
+	m.SetLoad(0.25, func(node string) int64 {
+		return loads[node]
+	}, func() int64 {
+		var total int64
+		for _, load := range loads {
+			total += load
+		}
+		return total
+	})
+	if m.GetPeer("11") != "2" {
+		t.Fatal("idle ring should pick the owner")
+	}
+
+	// 平均负载为(6+1)/3 上限为ceil(7/3*1.25)=3 节点2已经超过上限
+	loads["2"] = 6
+	testcases := map[string]string{
+		"11": "5", // 12属于节点2 沿哈希环选择15
+		"23": "5",
+		"27": "8",
+		"29": "5", // 绕回2 下一个是5
+	}
+	for k, v := range testcases {
+		if m.GetPeer(k) != v {
+			t.Errorf("hashing %s, want %s, but got %s", k, v, m.GetPeer(k))
+		}
+	}
+	// key所在的节点不受负载影响
+	if m.Owner("11") != "2" {
+		t.Fatal("owner should ignore load")
+	}
+
+	m.SetLoad(0, nil, nil)
+	if m.GetPeer("11") != "2" {
+		t.Fatal("bounded load should be disabled")
+	}
+}
@@ -1,22 +1,37 @@
 package hyliocache
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/hylio/hyliocache/bloom"
 	pb "github.com/hylio/hyliocache/hyliocachepb"
+	"github.com/hylio/hyliocache/lru"
 	"github.com/hylio/hyliocache/singleflight"
 	"log"
+	"math/rand"
 	"sync"
+	"time"
 )
 
 /*
 hyliocache/
     |--lru/
         |--lru.go    // lru 缓存淘汰策略
+        |--lfu.go    // lfu 缓存淘汰策略
+        |--arc.go    // arc 自适应缓存淘汰策略
+    |--tinylfu/      // W-TinyLFU 带准入控制的缓存淘汰策略
+    |--bloom/        // 布隆过滤器 防止缓存穿透
     |--byteview.go   // 缓存值的抽象与封装
     |--cache.go      // 并发控制
+    |--batch.go      // 批量获取 按节点合并rpc
     |--hyliocache.go // 负责与外部交互，控制缓存存储和获取的主流程
 */
 
+// ErrNotFound Getter确定数据源中不存在key时返回 可以用fmt.Errorf("%w")包装
+// 启用负缓存时 只有这个错误会被缓存 其他错误视为临时故障
+var ErrNotFound = errors.New("hyliocache: not found")
+
 // Getter 实现从数据源获取数据的能力
 type Getter interface {
 	Get(key string) ([]byte, error)
@@ -29,13 +44,148 @@ func (g GetterFunc) Get(key string) ([]byte, error) {
 	return g(key)
 }
 
+// GetterWithContext 从数据源获取数据时可以感知调用方的超时和取消
+type GetterWithContext interface {
+	GetContext(ctx context.Context, key string) ([]byte, error)
+}
+
+// GetterWithContextFunc 使得任意函数只要通过GetterWithContextFunc的转换 就能实现GetterWithContext接口
+type GetterWithContextFunc func(ctx context.Context, key string) ([]byte, error)
+
+func (g GetterWithContextFunc) GetContext(ctx context.Context, key string) ([]byte, error) {
+	return g(ctx, key)
+}
+
+// getterAdapter 把Getter转换为GetterWithContext 忽略ctx
+type getterAdapter struct {
+	Getter
+}
+
+func (g getterAdapter) GetContext(_ context.Context, key string) ([]byte, error) {
+	return g.Get(key)
+}
+
 // Group 定义一块缓存空间
 type Group struct {
 	name      string
-	getter    Getter
+	getter    GetterWithContext
 	mainCache cache
-	peers     PeerPicker
-	loader    *singleflight.Group
+	// hotCache 保存由其他节点负责 但在本节点访问频繁的缓存 避免每次都通过rpc获取
+	// 为nil时表示不启用
+	hotCache    *cache
+	hotRatio    float64       // hotCache占cacheBytes的比例
+	hotFraction int           // 从远端获取的数据 有1/hotFraction的概率放入hotCache
+	hotTTL      time.Duration // hotCache中数据的过期时间
+	// negCache 负缓存 保存数据源中不存在的key 防止缓存穿透 为nil时表示不启用
+	negCache *cache
+	negTTL   time.Duration
+	// filter 布隆过滤器 一定不存在的key直接返回 为nil时表示不启用
+	filter *bloom.Filter
+	peers  PeerPicker
+	loader *singleflight.Group
+	// localLoader 合并从数据源的加载 本节点的请求和其他节点转发来的请求共享
+	localLoader *singleflight.Group
+	ttl         time.Duration // 缓存的默认过期时间 0表示永不过期
+	// grace 数据过期后仍然可以返回旧值的时间 同时在后台刷新 0表示不启用
+	grace time.Duration
+	// refreshAhead 数据在有效期的最后refreshAhead比例内被访问时提前在后台刷新 0表示不启用
+	refreshAhead float64
+	// refreshQueue 后台刷新的任务队列 由固定数量的worker处理 为nil时每次刷新启动一个goroutine
+	refreshQueue   chan string
+	refreshWorkers int
+	refreshing     sync.Map // 正在后台刷新的key
+}
+
+const (
+	// defaultHotFraction 从远端获取的数据 默认有1/10的概率放入hotCache
+	defaultHotFraction = 10
+	// defaultHotTTL hotCache中数据的默认过期时间
+	defaultHotTTL = time.Minute
+	// negCacheRatio 负缓存占cacheBytes的比例
+	negCacheRatio = 1.0 / 16
+	// refreshQueueSize 每个刷新worker排队的任务数 队列满时放弃刷新
+	refreshQueueSize = 16
+)
+
+// GroupOption 用于在NewGroup时配置Group
+type GroupOption func(*Group)
+
+// WithTTL 设置Group中缓存的默认过期时间
+func WithTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.ttl = ttl
+	}
+}
+
+// WithStaleWhileRevalidate 数据过期后的grace时间内仍然返回旧值 同时在后台刷新一次
+// 需要和WithTTL一起使用 热点key过期时调用方不必等待数据源
+func WithStaleWhileRevalidate(grace time.Duration) GroupOption {
+	return func(g *Group) {
+		g.grace = grace
+	}
+}
+
+// WithRefreshAhead 数据在有效期的最后ratio比例内被访问时 在后台提前刷新 热点key因此不会过期
+// 后台刷新由workers个goroutine处理 workers小于1时按1处理 刷新任务过多时直接放弃 避免压垮数据源 需要和WithTTL一起使用
+func WithRefreshAhead(ratio float64, workers int) GroupOption {
+	return func(g *Group) {
+		if workers < 1 {
+			workers = 1
+		}
+		g.refreshAhead = ratio
+		g.refreshWorkers = workers
+	}
+}
+
+// WithShards 把缓存分成n个独立加锁的分片 cacheBytes平均分给每个分片 默认为1
+// 每个分片只有cacheBytes/n的容量 超过这个大小的value不会被缓存 会计入CacheStats.TooLarge
+func WithShards(n int) GroupOption {
+	return func(g *Group) {
+		g.mainCache.nshards = n
+	}
+}
+
+// WithHotCache 启用hotCache 从cacheBytes中划出ratio比例的内存给hotCache ratio需要在(0,1)之间
+// hotCache中是其他节点负责的数据 其他节点Set或Remove时不会通知本节点
+// 因此这些数据最多在WithHotCacheTTL的时间内(默认1分钟 不超过WithTTL)返回旧值
+func WithHotCache(ratio float64) GroupOption {
+	return func(g *Group) {
+		g.hotRatio = ratio
+	}
+}
+
+// WithHotCacheTTL 设置hotCache中数据的过期时间 也就是其他节点更新数据后本节点最多返回旧值的时间 ttl<=0时使用默认值
+func WithHotCacheTTL(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		if ttl > 0 {
+			g.hotTTL = ttl
+		}
+	}
+}
+
+// WithNegativeCache 启用负缓存 Getter返回ErrNotFound的key在ttl内直接返回ErrNotFound
+// 负缓存的内存从cacheBytes中划出
+func WithNegativeCache(ttl time.Duration) GroupOption {
+	return func(g *Group) {
+		g.negTTL = ttl
+	}
+}
+
+// WithBloomFilter 为Group设置布隆过滤器 过滤器中一定不存在的key直接返回ErrNotFound
+// 应用需要用所有合法的key初始化过滤器 并在新增数据时调用Add 或通过Group.Set写入
+// 过滤器只在本节点生效 Group.Set只会更新发起写入的节点和key所在节点的过滤器
+// 其他节点可以用Filter.MarshalBinary导出 再用Filter.UnmarshalBinary定期同步 比如都从数据源重建或由同一个节点分发
+func WithBloomFilter(f *bloom.Filter) GroupOption {
+	return func(g *Group) {
+		g.filter = f
+	}
+}
+
+// WithPolicy 设置Group使用的淘汰策略 如lru.LRU、lru.LFU、lru.ARC、tinylfu.NewPolicy 默认为lru.LRU
+func WithPolicy(newPolicy lru.NewPolicy) GroupOption {
+	return func(g *Group) {
+		g.mainCache.newPolicy = newPolicy
+	}
 }
 
 var (
@@ -44,17 +194,57 @@ var (
 )
 
 // NewGroup 新建一块缓存空间
-func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
+func NewGroup(name string, cacheBytes int64, getter Getter, opts ...GroupOption) *Group {
+	if getter == nil {
+		panic("no getter")
+	}
+	return NewGroupWithContext(name, cacheBytes, getterAdapter{getter}, opts...)
+}
+
+// NewGroupWithContext 新建一块缓存空间 getter可以感知GetContext传入的ctx
+func NewGroupWithContext(name string, cacheBytes int64, getter GetterWithContext, opts ...GroupOption) *Group {
 	if getter == nil {
 		panic("no getter")
 	}
 	mu.Lock()
 	defer mu.Unlock()
 	g := &Group{
-		name:      name,
-		getter:    getter,
-		mainCache: cache{cacheBytes: cacheBytes},
-		loader:    &singleflight.Group{},
+		name:        name,
+		getter:      getter,
+		mainCache:   cache{cacheBytes: cacheBytes},
+		hotFraction: defaultHotFraction,
+		hotTTL:      defaultHotTTL,
+		loader:      &singleflight.Group{},
+		localLoader: &singleflight.Group{},
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	if g.hotRatio < 0 || g.hotRatio >= 1 {
+		panic("hot cache ratio must be in (0, 1)")
+	}
+	if g.hotRatio > 0 {
+		hotBytes := int64(float64(cacheBytes) * g.hotRatio)
+		g.mainCache.cacheBytes -= hotBytes
+		g.hotCache = &cache{nshards: g.mainCache.nshards, cacheBytes: hotBytes}
+		g.hotCache.init()
+	}
+	if g.negTTL > 0 {
+		negBytes := int64(float64(cacheBytes) * negCacheRatio)
+		g.mainCache.cacheBytes -= negBytes
+		g.negCache = &cache{nshards: g.mainCache.nshards, cacheBytes: negBytes}
+		g.negCache.init()
+	}
+	// cacheBytes为0表示不限制 否则hotCache和负缓存划走之后mainCache仍然需要有容量
+	if cacheBytes > 0 && g.mainCache.cacheBytes <= 0 {
+		panic("hot cache and negative cache leave no room for main cache")
+	}
+	g.mainCache.init()
+	if g.refreshWorkers > 0 {
+		g.refreshQueue = make(chan string, g.refreshWorkers*refreshQueueSize)
+		for i := 0; i < g.refreshWorkers; i++ {
+			go g.refreshWorker()
+		}
 	}
 	groups[name] = g
 	return g
@@ -68,55 +258,185 @@ func GetGroup(name string) *Group {
 }
 
 func (g *Group) Get(key string) (ByteView, error) {
+	return g.GetContext(context.Background(), key)
+}
+
+// GetContext 获取缓存 调用方取消或超时后立即返回ctx.Err()
+// 并发的相同请求共享一次加载 所有调用方都放弃等待后才会取消对数据源和远端节点的请求
+func (g *Group) GetContext(ctx context.Context, key string) (ByteView, error) {
 	if key == "" {
 		return ByteView{}, fmt.Errorf("key is required")
 	}
+	if v, ok, err := g.lookupCache(key); ok {
+		return v, err
+	}
+	// 缓存未命中
+	return g.load(ctx, key)
+}
 
-	if v, ok := g.mainCache.get(key); ok {
+// lookupCache 依次查找mainCache、hotCache、负缓存和布隆过滤器 ok为false时需要加载
+func (g *Group) lookupCache(key string) (value ByteView, ok bool, err error) {
+	if e, ok := g.mainCache.lookup(key); ok {
 		log.Println("hyliocache hit!")
-		return v, nil
+		if now := time.Now(); e.stale(now) || (g.refreshAhead > 0 && e.refreshDue(now, g.refreshAhead)) {
+			g.revalidate(key)
+		}
+		return e.value, true, nil
+	}
+	if g.hotCache != nil {
+		if v, ok := g.hotCache.get(key); ok {
+			log.Println("hyliocache hot cache hit!")
+			return v, true, nil
+		}
+	}
+	if g.negCache != nil {
+		if _, ok := g.negCache.get(key); ok {
+			return ByteView{}, true, ErrNotFound
+		}
+	}
+	// key一定不存在 不必经过singleflight、远端节点和数据源
+	if g.filter != nil && !g.filter.Test(key) {
+		return ByteView{}, true, ErrNotFound
+	}
+	return ByteView{}, false, nil
+}
+
+// revalidate 在后台重新加载key 同一个key同时只会有一个刷新任务
+// 启用WithRefreshAhead时任务交给worker处理 队列已满时放弃本次刷新
+func (g *Group) revalidate(key string) {
+	if _, loading := g.refreshing.LoadOrStore(key, struct{}{}); loading {
+		return
+	}
+	if g.refreshQueue == nil {
+		go g.refresh(key)
+		return
+	}
+	select {
+	case g.refreshQueue <- key:
+	default:
+		g.refreshing.Delete(key)
+	}
+}
+
+func (g *Group) refreshWorker() {
+	for key := range g.refreshQueue {
+		g.refresh(key)
 	}
-	// 缓存未命中
-	return g.load(key)
 }
 
-func (g *Group) load(key string) (value ByteView, err error) {
-	view, err2 := g.loader.Do(key, func() (interface{}, error) {
+// refresh 从数据源重新加载mainCache中的key 不经过PickPeer
+// 远端节点返回的数据不会更新mainCache 否则过期前的每次访问都会再请求一次远端节点
+func (g *Group) refresh(key string) {
+	defer g.refreshing.Delete(key)
+	if _, err := g.loadLocally(context.Background(), key); err != nil {
+		log.Println("[hylioCache] Failed to refresh", key, err)
+	}
+}
+
+func (g *Group) load(ctx context.Context, key string) (ByteView, error) {
+	// 数据在所有等待的调用方共享的ctx中加载 某个调用方超时不会让其他调用方失败
+	view, err := g.loader.DoContext(ctx, key, func(ctx context.Context) (interface{}, error) {
 		if g.peers != nil {
 			if peer, ok := g.peers.PickPeer(key); ok {
-				if value, err = g.getFromPeer(key, peer); err == nil {
+				value, err := g.getFromPeer(ctx, key, peer)
+				if err == nil {
+					g.populateHotCache(key, value)
 					return value, nil
 				}
-				log.Println("[hylioCache] Failed to get from peer", err)
+				if err := g.peerFailed(ctx, err); err != nil {
+					return nil, err
+				}
 			}
 		}
-		return g.getLocally(key)
+		return g.loadLocally(ctx, key)
 	})
-	if err2 == nil {
-		return view.(ByteView), err2
+	if err != nil {
+		return ByteView{}, err
 	}
-	return
+	return view.(ByteView), nil
 }
 
-func (g *Group) getLocally(key string) (ByteView, error) {
-	bytes, err := g.getter.Get(key)
+// getForPeer 处理其他节点发来的请求 只查找本节点的缓存和数据源 不再通过PickPeer转发
+// 有界负载会把key交给不负责它的节点 节点变化时各节点的哈希环也可能暂时不一致 再次转发会回到原来的节点
+func (g *Group) getForPeer(ctx context.Context, key string) (ByteView, error) {
+	if key == "" {
+		return ByteView{}, fmt.Errorf("key is required")
+	}
+	if v, ok, err := g.lookupCache(key); ok {
+		return v, err
+	}
+	return g.loadLocally(ctx, key)
+}
+
+// loadLocally 从数据源加载key 同一个key同时只有一次加载
+func (g *Group) loadLocally(ctx context.Context, key string) (ByteView, error) {
+	view, err := g.localLoader.DoContext(ctx, key, func(ctx context.Context) (interface{}, error) {
+		return g.getLocally(ctx, key)
+	})
 	if err != nil {
 		return ByteView{}, err
 	}
+	return view.(ByteView), nil
+}
+
+// populateHotCache 把远端节点返回的数据放入hotCache
+// 只保留一部分远端数据 避免hotCache被只访问一次的key占满
+func (g *Group) populateHotCache(key string, value ByteView) {
+	if g.hotCache != nil && rand.Intn(g.hotFraction) == 0 {
+		g.populateCache(key, value, g.hotCache)
+	}
+}
+
+// peerFailed 处理从远端节点获取失败的情况 返回nil时回退到数据源加载
+// 远端节点确认数据不存在时返回ErrNotFound 调用方已经放弃时返回ctx.Err() 不必再访问数据源
+func (g *Group) peerFailed(ctx context.Context, err error) error {
+	if errors.Is(err, ErrNotFound) {
+		return err
+	}
+	log.Println("[hylioCache] Failed to get from peer", err)
+	return ctx.Err()
+}
+
+func (g *Group) getLocally(ctx context.Context, key string) (ByteView, error) {
+	bytes, err := g.getter.GetContext(ctx, key)
+	if err != nil {
+		// 只缓存确定不存在的key 临时故障不缓存
+		if g.negCache != nil && errors.Is(err, ErrNotFound) {
+			g.negCache.add(key, ByteView{}, time.Time{}, time.Now().Add(g.negTTL))
+		}
+		return ByteView{}, err
+	}
 	// 返回一个深拷贝 而不是源数据
 	value := ByteView{b: cloneBytes(bytes)}
-	g.populateCache(key, value)
+	g.populateLoaded(key, value)
 	return value, nil
 }
 
-func (g *Group) getFromPeer(key string, peer PeerGetter) (ByteView, error) {
+// populateLoaded 缓存从数据源加载的数据 本节点负责的key放入mainCache
+// 其他节点负责的key(有界负载分给本节点的 或者远端节点失败后在本地加载的)只放入hotCache
+// key所在节点的Remove不会通知到这些副本 只能依靠hotCache较短的过期时间
+func (g *Group) populateLoaded(key string, value ByteView) {
+	if g.peers != nil {
+		if _, ok := g.peers.PickOwner(key); ok {
+			// key已经不由本节点负责 mainCache中的旧值不会再被Remove清除
+			g.mainCache.remove(key)
+			if g.hotCache != nil {
+				g.populateCache(key, value, g.hotCache)
+			}
+			return
+		}
+	}
+	g.populateCache(key, value, &g.mainCache)
+}
+
+func (g *Group) getFromPeer(ctx context.Context, key string, peer PeerGetter) (ByteView, error) {
 	fmt.Println("[hyliocache]  getFromPeer begins, key :", key, fmt.Sprintf("peer: %+v", peer))
 	req := &pb.Request{
 		Group: g.name,
 		Key:   key,
 	}
 
-	bytes, err := peer.Get(req)
+	bytes, err := peer.Get(ctx, req)
 	fmt.Println("[hyliocache] get from peer: ", string(bytes))
 	if err != nil {
 		return ByteView{}, err
@@ -124,9 +444,102 @@ func (g *Group) getFromPeer(key string, peer PeerGetter) (ByteView, error) {
 	return ByteView{b: bytes}, nil
 }
 
+// Set 主动写入缓存 比如刚更新完数据源时 避免下一次读取再访问数据源
+// 注册了远端节点时 value会写入key所在的节点 即使这个节点的负载过高
+func (g *Group) Set(key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	if g.filter != nil {
+		g.filter.Add(key)
+	}
+	if g.peers != nil {
+		if peer, ok := g.peers.PickOwner(key); ok {
+			req := &pb.SetRequest{
+				Group: g.name,
+				Key:   key,
+				Value: value,
+			}
+			if err := peer.Set(context.Background(), req); err != nil {
+				return fmt.Errorf("failed to set %s to peer: %v", key, err)
+			}
+			// hotCache中可能有旧值
+			if g.hotCache != nil {
+				g.hotCache.remove(key)
+			}
+			return nil
+		}
+	}
+	g.populateCache(key, ByteView{b: cloneBytes(value)}, &g.mainCache)
+	return nil
+}
+
+// Remove 删除key对应的缓存 在数据源更新后使缓存失效
+// 注册了远端节点时 还会通知key所在的节点删除 其他节点hotCache中的副本在hotTTL内过期
+func (g *Group) Remove(key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	g.removeLocally(key)
+	if g.peers != nil {
+		if peer, ok := g.peers.PickOwner(key); ok {
+			req := &pb.Request{
+				Group: g.name,
+				Key:   key,
+			}
+			if err := peer.Delete(context.Background(), req); err != nil {
+				return fmt.Errorf("failed to remove %s from peer: %v", key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// removeLocally 只删除本地的缓存 返回缓存是否存在
+func (g *Group) removeLocally(key string) bool {
+	removed := g.mainCache.remove(key)
+	if g.hotCache != nil && g.hotCache.remove(key) {
+		removed = true
+	}
+	if g.negCache != nil && g.negCache.remove(key) {
+		removed = true
+	}
+	return removed
+}
+
 // populateCache 把最近访问过的 没有在缓存中的数据 保存在缓存中
-func (g *Group) populateCache(key string, value ByteView) {
-	g.mainCache.add(key, value)
+func (g *Group) populateCache(key string, value ByteView, cache *cache) {
+	ttl, grace := g.ttl, g.grace
+	// hotCache中的数据不会被其他节点的Set和Remove清除 总是在hotTTL内过期 变旧后也不再保留
+	if cache == g.hotCache {
+		if ttl <= 0 || ttl > g.hotTTL {
+			ttl = g.hotTTL
+		}
+		grace = 0
+	}
+	var staleAt, expire time.Time
+	if ttl > 0 {
+		staleAt = time.Now().Add(ttl)
+		expire = staleAt.Add(grace)
+	}
+	cache.add(key, value, staleAt, expire)
+	// 数据已经存在 负缓存失效
+	if g.negCache != nil {
+		g.negCache.remove(key)
+	}
+}
+
+// CacheStats 返回缓存的统计信息
+func (g *Group) CacheStats() CacheStats {
+	return g.mainCache.stats()
+}
+
+// HotCacheStats 返回hotCache的统计信息 未启用hotCache时为零值
+func (g *Group) HotCacheStats() CacheStats {
+	if g.hotCache == nil {
+		return CacheStats{}
+	}
+	return g.hotCache.stats()
 }
 
 // RegisterPeers 为Group初始化clients节点
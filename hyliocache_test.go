@@ -1,9 +1,16 @@
 package hyliocache
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"github.com/hylio/hyliocache/bloom"
+	pb "github.com/hylio/hyliocache/hyliocachepb"
+	"github.com/hylio/hyliocache/lru"
 	"reflect"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestGetter(t *testing.T) {
@@ -51,3 +58,545 @@ func TestGet(t *testing.T) {
 		t.Fatalf("should be empty, but got %s", view)
 	}
 }
+
+func TestGetWithTTL(t *testing.T) {
+	loads := 0
+	c := NewGroup("ttl_name", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(db[key]), nil
+	}), WithTTL(10*time.Millisecond))
+
+	if _, err := c.Get("zhanghao"); err != nil || loads != 1 {
+		t.Fatal("failed to get value")
+	}
+	if _, err := c.Get("zhanghao"); err != nil || loads != 1 {
+		t.Fatal("cache zhanghao miss")
+	}
+	// 过期之后 需要重新访问db
+	time.Sleep(20 * time.Millisecond)
+	if _, err := c.Get("zhanghao"); err != nil || loads != 2 {
+		t.Fatalf("expired entry should be reloaded, loads = %d", loads)
+	}
+}
+
+func TestGetWithPolicy(t *testing.T) {
+	c := NewGroup("lfu_name", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}), WithPolicy(lru.LFU))
+
+	if view, err := c.Get("wangrui"); err != nil || view.String() != "civet" {
+		t.Fatal("failed to get value")
+	}
+	if _, ok := c.mainCache.shards[0].policy.(*lru.LFUCache); !ok {
+		t.Fatalf("expect lfu policy, but got %T", c.mainCache.shards[0].policy)
+	}
+}
+
+func TestGetWithShards(t *testing.T) {
+	c := NewGroup("shard_name", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}), WithShards(4))
+
+	if len(c.mainCache.shards) != 4 || c.mainCache.shards[0].cacheBytes != 2<<10/4 {
+		t.Fatal("failed to split cache into shards")
+	}
+	for k, v := range db {
+		if view, err := c.Get(k); err != nil || view.String() != v {
+			t.Fatal("failed to get value")
+		}
+		if _, err := c.Get(k); err != nil {
+			t.Fatalf("cache %s miss", k)
+		}
+	}
+	stats := c.CacheStats()
+	if stats.Items != int64(len(db)) || stats.Gets != int64(2*len(db)) || stats.Hits != int64(len(db)) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestCacheStatsEvictions(t *testing.T) {
+	// 每个分片16字节
+	c := NewGroup("evict_stats_name", 64, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}), WithShards(4))
+
+	c.Set("zhanghao", []byte("hylio"))
+	c.Remove("zhanghao")
+	if stats := c.CacheStats(); stats.Evictions != 0 || stats.Items != 0 {
+		t.Fatalf("Remove should not count as eviction, got %+v", stats)
+	}
+	// 单个value超过分片容量 无法缓存
+	c.Set("zhanghao", []byte("a value larger than a shard"))
+	if stats := c.CacheStats(); stats.TooLarge != 1 || stats.Items != 0 {
+		t.Fatalf("oversized value should be counted, got %+v", stats)
+	}
+	// 同一个分片写满后淘汰旧值
+	shard := c.mainCache.shard("zhanghao")
+	for i := 0; ; i++ {
+		key := fmt.Sprintf("k%d", i)
+		if c.mainCache.shard(key) != shard {
+			continue
+		}
+		c.Set(key, []byte("0123456789"))
+		if c.CacheStats().Evictions > 0 {
+			break
+		}
+	}
+}
+
+// fakePeer 模拟远端节点
+type fakePeer struct {
+	gets    int
+	deleted []string
+	set     map[string]string
+	batches [][]string
+	// failing 中的key在BatchGet时返回加载失败
+	failing map[string]bool
+}
+
+func (p *fakePeer) Get(ctx context.Context, in *pb.Request) ([]byte, error) {
+	p.gets++
+	return []byte("peer:" + in.GetKey()), nil
+}
+
+func (p *fakePeer) Delete(ctx context.Context, in *pb.Request) error {
+	p.deleted = append(p.deleted, in.GetKey())
+	return nil
+}
+
+func (p *fakePeer) Set(ctx context.Context, in *pb.SetRequest) error {
+	if p.set == nil {
+		p.set = make(map[string]string)
+	}
+	p.set[in.GetKey()] = string(in.GetValue())
+	return nil
+}
+
+// BatchGet 远端节点上不存在db之外的key
+func (p *fakePeer) BatchGet(ctx context.Context, in *pb.BatchRequest) (*pb.BatchResponse, error) {
+	p.batches = append(p.batches, in.GetKeys())
+	resp := &pb.BatchResponse{}
+	for _, key := range in.GetKeys() {
+		if p.failing[key] {
+			resp.Results = append(resp.Results, &pb.BatchResult{Key: key, Error: "load failed"})
+			continue
+		}
+		if _, ok := db[key]; !ok {
+			resp.Results = append(resp.Results, &pb.BatchResult{Key: key, NotFound: true})
+			continue
+		}
+		resp.Results = append(resp.Results, &pb.BatchResult{Key: key, Value: []byte("peer:" + key)})
+	}
+	return resp, nil
+}
+
+// fakePicker remote中的key由远端节点负责 其他key由本地负责
+type fakePicker struct {
+	peer   *fakePeer
+	remote map[string]bool
+}
+
+func (p *fakePicker) PickOwner(key string) (PeerGetter, bool) {
+	return p.PickPeer(key)
+}
+
+func (p *fakePicker) PickPeer(key string) (PeerGetter, bool) {
+	if p.remote[key] {
+		return p.peer, true
+	}
+	return nil, false
+}
+
+func TestRemove(t *testing.T) {
+	loads := 0
+	c := NewGroup("remove_name", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(db[key]), nil
+	}))
+	peer := &fakePeer{}
+	c.RegisterPeers(&fakePicker{peer: peer, remote: map[string]bool{"wangrui": true}})
+
+	c.Get("zhanghao")
+	if err := c.Remove("zhanghao"); err != nil || len(peer.deleted) != 0 {
+		t.Fatal("local key should be removed locally")
+	}
+	// 删除之后 需要重新访问db
+	if _, err := c.Get("zhanghao"); err != nil || loads != 2 {
+		t.Fatalf("removed key should be reloaded, loads = %d", loads)
+	}
+	if err := c.Remove("wangrui"); err != nil || !reflect.DeepEqual(peer.deleted, []string{"wangrui"}) {
+		t.Fatal("remote key should be removed from peer")
+	}
+}
+
+func TestSet(t *testing.T) {
+	loads := 0
+	c := NewGroup("set_name", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		return []byte(db[key]), nil
+	}))
+	peer := &fakePeer{}
+	c.RegisterPeers(&fakePicker{peer: peer, remote: map[string]bool{"wangrui": true}})
+
+	// 本地负责的key直接写入缓存 不需要访问db
+	if err := c.Set("zhanghao", []byte("hylio2")); err != nil {
+		t.Fatal("failed to set value")
+	}
+	if view, err := c.Get("zhanghao"); err != nil || view.String() != "hylio2" || loads != 0 {
+		t.Fatalf("set value should be cached, loads = %d", loads)
+	}
+	if err := c.Set("wangrui", []byte("civet2")); err != nil || peer.set["wangrui"] != "civet2" {
+		t.Fatal("remote key should be set to peer")
+	}
+}
+
+func TestGetContext(t *testing.T) {
+	c := NewGroupWithContext("ctx_name", 2<<10, GetterWithContextFunc(func(ctx context.Context, key string) ([]byte, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+			return []byte(db[key]), nil
+		}
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := c.GetContext(ctx, "zhanghao"); err != context.DeadlineExceeded {
+		t.Fatalf("expect %v, but got %v", context.DeadlineExceeded, err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatal("getter should stop when ctx is done")
+	}
+}
+
+func TestGetContextSharedLoad(t *testing.T) {
+	var loads int32
+	release := make(chan struct{})
+	c := NewGroupWithContext("ctx_shared_name", 2<<10, GetterWithContextFunc(func(ctx context.Context, key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-release:
+			return []byte(db[key]), nil
+		}
+	}))
+
+	// 第一个调用方发起加载后放弃 第二个调用方仍然应该拿到数据
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := c.GetContext(ctx, "zhanghao")
+		first <- err
+	}()
+	for atomic.LoadInt32(&loads) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	second := make(chan error)
+	go func() {
+		view, err := c.Get("zhanghao")
+		if err == nil && view.String() != "hylio" {
+			err = fmt.Errorf("unexpected value %s", view)
+		}
+		second <- err
+	}()
+	// 等待第二个调用方加入同一次加载
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Fatalf("expect %v, but got %v", context.Canceled, err)
+	}
+	close(release)
+	if err := <-second; err != nil {
+		t.Fatalf("caller with a live ctx should get the value, got %v", err)
+	}
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Fatalf("callers should share one load, loads = %d", n)
+	}
+}
+
+func TestGetContextDeadline(t *testing.T) {
+	started, release := make(chan time.Time, 1), make(chan struct{})
+	finished := make(chan time.Time, 1)
+	c := NewGroupWithContext("ctx_deadline_name", 2<<10, GetterWithContextFunc(func(ctx context.Context, key string) ([]byte, error) {
+		d, _ := ctx.Deadline()
+		started <- d
+		<-release
+		d, _ = ctx.Deadline()
+		finished <- d
+		return []byte(db[key]), nil
+	}))
+
+	// 数据源能看到调用方的截止时间
+	first, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	errs := make(chan error, 2)
+	go func() {
+		_, err := c.GetContext(first, "zhanghao")
+		errs <- err
+	}()
+	if d, _ := first.Deadline(); !(<-started).Equal(d) {
+		t.Fatal("getter should see the caller's deadline")
+	}
+	// 截止时间更晚的调用方加入后 截止时间延长
+	second, cancel2 := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel2()
+	go func() {
+		_, err := c.GetContext(second, "zhanghao")
+		errs <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	if d, _ := second.Deadline(); !(<-finished).Equal(d) {
+		t.Fatal("deadline should be extended to the latest caller's")
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestHotCache(t *testing.T) {
+	c := NewGroup("hot_name", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}), WithHotCache(0.25))
+	// 每个远端数据都放入hotCache
+	c.hotFraction = 1
+	peer := &fakePeer{}
+	c.RegisterPeers(&fakePicker{peer: peer, remote: map[string]bool{"wangrui": true}})
+
+	if c.mainCache.cacheBytes != 2<<10-2<<10/4 || c.hotCache.cacheBytes != 2<<10/4 {
+		t.Fatal("hot cache bytes should be carved out of cacheBytes")
+	}
+	for i := 0; i < 3; i++ {
+		if view, err := c.Get("wangrui"); err != nil || view.String() != "peer:wangrui" {
+			t.Fatal("failed to get value from peer")
+		}
+	}
+	if peer.gets != 1 || c.HotCacheStats().Items != 1 || c.CacheStats().Items != 0 {
+		t.Fatalf("remote value should be kept in hot cache, peer gets = %d", peer.gets)
+	}
+	// 删除时hotCache中的数据也要删除
+	c.Remove("wangrui")
+	if c.HotCacheStats().Items != 0 {
+		t.Fatal("hot cache entry should be removed")
+	}
+}
+
+func TestHotCacheRatio(t *testing.T) {
+	for _, ratio := range []float64{-0.1, 1, 2, 0.95} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("hot cache ratio %v should be rejected", ratio)
+				}
+			}()
+			NewGroup("hot_ratio_name", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+				return nil, ErrNotFound
+			}), WithHotCache(ratio), WithNegativeCache(time.Second))
+		}()
+	}
+}
+
+func TestHotCacheTTL(t *testing.T) {
+	c := NewGroup("hot_ttl_name", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}), WithHotCache(0.25), WithHotCacheTTL(20*time.Millisecond))
+	c.hotFraction = 1
+	peer := &fakePeer{}
+	c.RegisterPeers(&fakePicker{peer: peer, remote: map[string]bool{"wangrui": true}})
+
+	// 没有设置WithTTL时 hotCache中的数据也会过期 之后重新从远端获取
+	c.Get("wangrui")
+	c.Get("wangrui")
+	if peer.gets != 1 {
+		t.Fatalf("hot cache should serve the value, peer gets = %d", peer.gets)
+	}
+	time.Sleep(30 * time.Millisecond)
+	c.Get("wangrui")
+	if peer.gets != 2 {
+		t.Fatalf("expired hot cache entry should be fetched again, peer gets = %d", peer.gets)
+	}
+}
+
+func TestNegativeCache(t *testing.T) {
+	loads := make(map[string]int)
+	c := NewGroup("neg_name", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads[key]++
+		if key == "broken" {
+			return nil, fmt.Errorf("db is down")
+		}
+		if v, ok := db[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}), WithNegativeCache(time.Minute))
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.Get("unknown"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expect ErrNotFound, but got %v", err)
+		}
+		if _, err := c.Get("broken"); err == nil || errors.Is(err, ErrNotFound) {
+			t.Fatalf("expect transient error, but got %v", err)
+		}
+	}
+	if loads["unknown"] != 1 {
+		t.Fatalf("missing key should be cached, loads = %d", loads["unknown"])
+	}
+	if loads["broken"] != 3 {
+		t.Fatalf("transient error should not be cached, loads = %d", loads["broken"])
+	}
+	// 写入之后负缓存失效
+	c.Set("unknown", []byte("known"))
+	if view, err := c.Get("unknown"); err != nil || view.String() != "known" {
+		t.Fatal("negative entry should be dropped after set")
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	loads := 0
+	f := bloom.New(100, 0.01)
+	for k := range db {
+		f.Add(k)
+	}
+	c := NewGroup("bloom_name", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		loads++
+		if v, ok := db[key]; ok {
+			return []byte(v), nil
+		}
+		return nil, fmt.Errorf("%s: %w", key, ErrNotFound)
+	}), WithBloomFilter(f))
+
+	if view, err := c.Get("zhanghao"); err != nil || view.String() != "hylio" {
+		t.Fatal("failed to get value")
+	}
+	if _, err := c.Get("unknown"); !errors.Is(err, ErrNotFound) || loads != 1 {
+		t.Fatalf("unknown key should be filtered, loads = %d", loads)
+	}
+	// 通过Set写入的key会加入过滤器
+	c.Set("unknown", []byte("known"))
+	if !f.Test("unknown") {
+		t.Fatal("key set should be added to filter")
+	}
+	// 其他节点通过rpc写入到本节点的key也会加入过滤器
+	s := rpcServer{NewServer("127.0.0.1:8000")}
+	if _, err := s.Set(context.Background(), &pb.SetRequest{Group: "bloom_name", Key: "remote", Value: []byte("v")}); err != nil {
+		t.Fatal(err)
+	}
+	if view, err := c.Get("remote"); err != nil || view.String() != "v" {
+		t.Fatal("key set by peer should not be filtered")
+	}
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	var loads int32
+	release := make(chan struct{})
+	c := NewGroup("swr_name", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		n := atomic.AddInt32(&loads, 1)
+		// 后台刷新在读完旧值之后才完成
+		if n > 1 {
+			<-release
+		}
+		return []byte(fmt.Sprintf("%s%d", db[key], n)), nil
+	}), WithTTL(10*time.Millisecond), WithStaleWhileRevalidate(time.Second))
+
+	if view, err := c.Get("zhanghao"); err != nil || view.String() != "hylio1" {
+		t.Fatal("failed to get value")
+	}
+	// 数据变旧后 直接返回旧值 并在后台刷新
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		if view, err := c.Get("zhanghao"); err != nil || view.String() != "hylio1" {
+			t.Fatalf("stale value should be served, got %s", view)
+		}
+	}
+	close(release)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if view, _ := c.Get("zhanghao"); view.String() == "hylio2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stale value should be refreshed in background")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&loads); n != 2 {
+		t.Fatalf("refresh should run once, loads = %d", n)
+	}
+}
+
+func TestRefreshLocally(t *testing.T) {
+	var loads int32
+	c := NewGroup("refresh_local_name", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		return []byte(db[key]), nil
+	}), WithTTL(10*time.Millisecond), WithStaleWhileRevalidate(time.Second))
+	picker := &fakePicker{peer: &fakePeer{}, remote: map[string]bool{}}
+	c.RegisterPeers(picker)
+
+	c.Get("zhanghao")
+	// 节点变化后key由远端节点负责 mainCache中的旧值仍然只从数据源刷新
+	picker.remote["zhanghao"] = true
+	time.Sleep(20 * time.Millisecond)
+	if view, err := c.Get("zhanghao"); err != nil || view.String() != "hylio" {
+		t.Fatal("stale value should be served")
+	}
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&loads) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("stale value should be refreshed from the getter")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if picker.peer.gets != 0 {
+		t.Fatalf("refresh should not go to peers, peer gets = %d", picker.peer.gets)
+	}
+}
+
+func TestRefreshAhead(t *testing.T) {
+	var loads int32
+	c := NewGroup("refresh_name", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		n := atomic.AddInt32(&loads, 1)
+		return []byte(fmt.Sprintf("%s%d", db[key], n)), nil
+	}), WithTTL(200*time.Millisecond), WithRefreshAhead(0.5, 1))
+
+	if view, err := c.Get("zhanghao"); err != nil || view.String() != "hylio1" {
+		t.Fatal("failed to get value")
+	}
+	// 有效期的前半段 不刷新
+	c.Get("zhanghao")
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Fatalf("fresh value should not be refreshed, loads = %d", n)
+	}
+	// 有效期的后半段 返回当前值 并在过期前完成刷新
+	time.Sleep(120 * time.Millisecond)
+	if view, err := c.Get("zhanghao"); err != nil || view.String() != "hylio1" {
+		t.Fatalf("cached value should be served, got %s", view)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		if view, _ := c.Get("zhanghao"); view.String() == "hylio2" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("value should be refreshed ahead of expiry")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&loads); n != 2 {
+		t.Fatalf("refresh should run once, loads = %d", n)
+	}
+}
+
+func TestRefreshAheadDefaultWorkers(t *testing.T) {
+	c := NewGroup("refresh_workers_name", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}), WithTTL(time.Second), WithRefreshAhead(0.5, 0))
+	if c.refreshWorkers != 1 || c.refreshQueue == nil {
+		t.Fatalf("refresh ahead should use at least one worker, got %d", c.refreshWorkers)
+	}
+}
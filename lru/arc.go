@@ -0,0 +1,250 @@
+package lru
+
+import (
+	"container/list"
+	"time"
+)
+
+/*
+	ARC 自适应替换缓存
+	T1保存只访问过一次的元素 T2保存访问过多次的元素
+	B1/B2是幽灵链表 只保存从T1/T2淘汰的key和当时的大小 不保存value
+	命中B1说明T1太小 增大T1的目标大小p 命中B2说明T2太小 减小p
+	这样缓存可以在偏重最近访问和偏重访问频次之间自动调整
+	所有大小都按字节计算 与Cache一样 元素大小包括key的长度
+	T1+T2不超过maxBytes 幽灵链表记录的大小合计也不超过maxBytes
+*/
+
+type arcList int
+
+const (
+	t1 arcList = iota
+	t2
+	b1
+	b2
+)
+
+type ARCCache struct {
+	maxBytes  int64                         // 最大内存限制
+	p         int64                         // T1的目标大小
+	bytes     [4]int64                      // 每个链表的大小
+	lists     [4]*list.List                 // 队尾为最近访问
+	cache     map[string]*list.Element      // 包括幽灵链表中的key
+	OnEvicted func(key string, value Value) // 回调函数 在淘汰数据时执行其他操作
+}
+
+type arcEntry struct {
+	key    string
+	value  Value // 幽灵链表中为nil
+	size   int64
+	expire time.Time // 过期时间 零值表示永不过期
+	in     arcList
+}
+
+func (e *arcEntry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+func NewARC(maxBytes int64, onEvicted func(string, Value)) *ARCCache {
+	return &ARCCache{
+		maxBytes:  maxBytes,
+		lists:     [4]*list.List{list.New(), list.New(), list.New(), list.New()},
+		cache:     make(map[string]*list.Element),
+		OnEvicted: onEvicted,
+	}
+}
+
+// Get 查找元素 命中后移动到T2 已过期的元素视为未命中
+func (c *ARCCache) Get(key string) (value Value, ok bool) {
+	ele, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	kv := ele.Value.(*arcEntry)
+	if kv.in == b1 || kv.in == b2 {
+		return nil, false
+	}
+	if kv.expired(time.Now()) {
+		c.drop(ele)
+		if c.OnEvicted != nil {
+			c.OnEvicted(kv.key, kv.value)
+		}
+		return nil, false
+	}
+	c.move(ele, t2)
+	return kv.value, true
+}
+
+// Remove 按照ARC的规则淘汰一个元素到幽灵链表
+func (c *ARCCache) Remove() {
+	c.replace(false, nil)
+}
+
+// Delete 删除指定的元素 幽灵链表中的key也会一并删除 返回元素是否在缓存中
+func (c *ARCCache) Delete(key string) bool {
+	ele, ok := c.cache[key]
+	if !ok {
+		return false
+	}
+	kv := ele.Value.(*arcEntry)
+	c.drop(ele)
+	if kv.in == b1 || kv.in == b2 {
+		return false
+	}
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value)
+	}
+	return true
+}
+
+// Add 添加或修改元素 元素永不过期
+func (c *ARCCache) Add(key string, value Value) (evicted []string, err error) {
+	return c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithExpire 添加或修改元素 expire为零值表示永不过期
+// 与Cache一样先腾出空间再写入 元素本身超过maxBytes时返回ErrTooLarge
+func (c *ARCCache) AddWithExpire(key string, value Value, expire time.Time) (evicted []string, err error) {
+	size := int64(value.Len()) + int64(len(key))
+	ele, ok := c.cache[key]
+	resident := ok && (ele.Value.(*arcEntry).in == t1 || ele.Value.(*arcEntry).in == t2)
+	if resident && (ele.Value.(*arcEntry).expired(time.Now()) || (c.maxBytes != 0 && size > c.maxBytes)) {
+		kv := ele.Value.(*arcEntry)
+		c.drop(ele)
+		evicted = append(evicted, key)
+		if c.OnEvicted != nil {
+			c.OnEvicted(kv.key, kv.value)
+		}
+		ok, resident = false, false
+	}
+	if c.maxBytes != 0 && size > c.maxBytes {
+		if ok {
+			c.drop(ele)
+		}
+		return evicted, ErrTooLarge
+	}
+	if resident {
+		// update 修改也算作一次访问 只需要为新旧值的差额腾出空间
+		kv := ele.Value.(*arcEntry)
+		c.move(ele, t2)
+		ele = c.cache[key]
+		evicted = c.makeRoom(size-kv.size, false, ele, evicted)
+		c.bytes[t2] += size - kv.size
+		kv.value, kv.size, kv.expire = value, size, expire
+	} else if ok {
+		kv := ele.Value.(*arcEntry)
+		inB2 := kv.in == b2
+		if inB2 {
+			// 命中B2 T2应该更大
+			c.p = max64(0, c.p-max64(size, size*c.bytes[b1]/max64(c.bytes[b2], 1)))
+		} else {
+			// 命中B1 T1应该更大
+			c.p = min64(c.maxBytes, c.p+max64(size, size*c.bytes[b2]/max64(c.bytes[b1], 1)))
+		}
+		evicted = c.makeRoom(size, inB2, nil, evicted)
+		c.bytes[kv.in] += size - kv.size
+		kv.value, kv.size, kv.expire = value, size, expire
+		c.move(ele, t2)
+	} else {
+		evicted = c.makeRoom(size, false, nil, evicted)
+		kv := &arcEntry{key: key, value: value, size: size, expire: expire, in: t1}
+		c.bytes[t1] += size
+		c.cache[key] = c.lists[t1].PushBack(kv)
+	}
+	if c.maxBytes == 0 {
+		return evicted, nil
+	}
+	// 限制幽灵链表的大小
+	for c.bytes[t1]+c.bytes[b1] > c.maxBytes && c.lists[b1].Len() > 0 {
+		c.drop(c.lists[b1].Front())
+	}
+	for c.bytes[t1]+c.bytes[t2]+c.bytes[b1]+c.bytes[b2] > 2*c.maxBytes && c.lists[b2].Len() > 0 {
+		c.drop(c.lists[b2].Front())
+	}
+	return evicted, nil
+}
+
+// makeRoom 淘汰元素 直到能再放下size大小的数据 被淘汰的key追加到evicted中返回
+func (c *ARCCache) makeRoom(size int64, inB2 bool, skip *list.Element, evicted []string) []string {
+	for c.maxBytes != 0 && c.bytes[t1]+c.bytes[t2]+size > c.maxBytes {
+		key, ok := c.replace(inB2, skip)
+		if !ok {
+			break
+		}
+		evicted = append(evicted, key)
+	}
+	return evicted
+}
+
+func (c *ARCCache) Len() int {
+	return c.lists[t1].Len() + c.lists[t2].Len()
+}
+
+func (c *ARCCache) Bytes() int64 {
+	return c.bytes[t1] + c.bytes[t2]
+}
+
+// replace T1超过目标大小p时淘汰T1 否则淘汰T2 被淘汰的key进入对应的幽灵链表
+// skip是正在修改的元素 不会被淘汰
+func (c *ARCCache) replace(inB2 bool, skip *list.Element) (key string, ok bool) {
+	from, other := t2, t1
+	if c.bytes[t1] > c.p || (inB2 && c.bytes[t1] == c.p) {
+		from, other = t1, t2
+	}
+	ele := c.lists[from].Front()
+	if ele == skip && ele != nil {
+		ele = ele.Next()
+	}
+	if ele == nil {
+		if ele = c.lists[other].Front(); ele == skip && ele != nil {
+			ele = ele.Next()
+		}
+	}
+	if ele == nil {
+		return "", false
+	}
+	kv := ele.Value.(*arcEntry)
+	value := kv.value
+	ghost := b1
+	if kv.in == t2 {
+		ghost = b2
+	}
+	c.move(ele, ghost)
+	kv.value = nil
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, value)
+	}
+	return kv.key, true
+}
+
+// move 把元素移动到链表to的队尾
+func (c *ARCCache) move(ele *list.Element, to arcList) {
+	kv := ele.Value.(*arcEntry)
+	c.lists[kv.in].Remove(ele)
+	c.bytes[kv.in] -= kv.size
+	kv.in = to
+	c.bytes[to] += kv.size
+	c.cache[kv.key] = c.lists[to].PushBack(kv)
+}
+
+// drop 彻底删除一个key 不进入幽灵链表
+func (c *ARCCache) drop(ele *list.Element) {
+	kv := ele.Value.(*arcEntry)
+	c.lists[kv.in].Remove(ele)
+	c.bytes[kv.in] -= kv.size
+	delete(c.cache, kv.key)
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
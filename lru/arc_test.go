@@ -0,0 +1,139 @@
+package lru
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestARCCache_Get(t *testing.T) {
+	arcCache := NewARC(0, nil)
+	arcCache.Add("name", Str("hylio"))
+	if v, ok := arcCache.Get("name"); !ok || string(v.(Str)) != "hylio" {
+		t.Error("hit fail")
+	}
+	if _, ok := arcCache.Get("age"); ok {
+		t.Error("miss fail")
+	}
+}
+
+func TestARCCache_Remove(t *testing.T) {
+	k1, k2, k3 := "name", "age", "sex"
+	v1, v2, v3 := "hylio", "24", "M"
+	cap := len(k1 + k2 + v1 + v2)
+	arcCache := NewARC(int64(cap), nil)
+	arcCache.Add(k1, Str(v1))
+	arcCache.Add(k2, Str(v2))
+	// k1被访问过两次 进入T2 应该淘汰T1中的k2
+	arcCache.Get(k1)
+	arcCache.Add(k3, Str(v3))
+	if _, ok := arcCache.Get(k1); !ok {
+		t.Error("hit k1 fail")
+	}
+	if _, ok := arcCache.Get(k2); ok || arcCache.Len() != 2 {
+		t.Error("remove k2 fail")
+	}
+	if arcCache.Bytes() > int64(cap) {
+		t.Errorf("bytes %d exceed %d", arcCache.Bytes(), cap)
+	}
+}
+
+func TestARCCache_Add(t *testing.T) {
+	k1 := "name"
+	v1 := "hylio"
+	v2 := "zh"
+	cap := len(k1 + v1)
+	arcCache := NewARC(int64(cap), nil)
+	arcCache.Add(k1, Str(v1))
+	if _, ok := arcCache.cache[k1]; !ok || arcCache.Bytes() != int64(cap) {
+		t.Error("hit k1 fail")
+	}
+	arcCache.Add(k1, Str(v2))
+	if v, ok := arcCache.Get(k1); !ok || string(v.(Str)) != v2 {
+		t.Error("update k1 fail")
+	}
+}
+
+func TestARCOnEvicted(t *testing.T) {
+	del := make([]string, 0)
+	callback := func(key string, value Value) {
+		del = append(del, key)
+	}
+	k1, k2, k3 := "name", "age", "sex"
+	v1, v2, v3 := "hylio", "24", "male"
+	cap := len(k1 + k2 + v1 + v2)
+	arcCache := NewARC(int64(cap), callback)
+	arcCache.Add(k1, Str(v1))
+	arcCache.Add(k2, Str(v2))
+	arcCache.Add(k3, Str(v3))
+	expect := []string{k1}
+
+	if !reflect.DeepEqual(expect, del) {
+		t.Fatalf("Call OnEvicted failed, expect keys equals to %s", expect)
+	}
+}
+
+func TestARCLimit(t *testing.T) {
+	k1, k2, k3 := "name", "age", "sex"
+	v1, v2, v3 := "hylio", "24", "malemalemalemale"
+	cap := 10
+	arcCache := NewARC(int64(cap), nil)
+	arcCache.Add(k1, Str(v1))
+	arcCache.Add(k2, Str(v2))
+	// 超过maxBytes的元素直接拒绝 不影响已有的元素
+	if _, err := arcCache.Add(k3, Str(v3)); err != ErrTooLarge {
+		t.Errorf("expect ErrTooLarge, but got %v", err)
+	}
+	if _, ok := arcCache.Get(k3); ok || arcCache.Len() != 1 {
+		t.Error("limit fail")
+	}
+}
+
+func TestARCGhost(t *testing.T) {
+	arcCache := NewARC(20, nil)
+	// k1 k2访问过两次 进入T2
+	arcCache.Add("k1", Str("001"))
+	arcCache.Add("k2", Str("002"))
+	arcCache.Get("k1")
+	arcCache.Get("k2")
+	arcCache.Add("k3", Str("003"))
+	arcCache.Add("k4", Str("004"))
+	// T1中的k3被淘汰到B1
+	arcCache.Add("k5", Str("005"))
+	if ele, ok := arcCache.cache["k3"]; !ok || ele.Value.(*arcEntry).in != b1 {
+		t.Fatal("k3 should be in b1")
+	}
+	// 再次写入k3时命中幽灵链表 T1的目标大小增大 且k3直接进入T2
+	arcCache.Add("k3", Str("003"))
+	if arcCache.p == 0 {
+		t.Error("p should grow after b1 hit")
+	}
+	if ele := arcCache.cache["k3"]; ele.Value.(*arcEntry).in != t2 {
+		t.Error("k3 should be in t2")
+	}
+	if ele := arcCache.cache["k4"]; ele.Value.(*arcEntry).in != b1 {
+		t.Error("k4 should be evicted to b1")
+	}
+	if arcCache.Bytes() > 20 {
+		t.Errorf("bytes %d exceed %d", arcCache.Bytes(), 20)
+	}
+}
+
+func TestARCExpire(t *testing.T) {
+	arcCache := NewARC(0, nil)
+	arcCache.AddWithExpire("name", Str("hylio"), time.Now().Add(-time.Second))
+	if _, ok := arcCache.Get("name"); ok || arcCache.Len() != 0 {
+		t.Error("expired entry should miss")
+	}
+}
+
+func TestARCCache_Delete(t *testing.T) {
+	arcCache := NewARC(0, nil)
+	arcCache.Add("name", Str("hylio"))
+	if !arcCache.Delete("name") || arcCache.Delete("age") {
+		t.Error("delete fail")
+	}
+	if _, ok := arcCache.Get("name"); ok || arcCache.Len() != 0 || arcCache.Bytes() != 0 {
+		t.Error("name should be deleted")
+	}
+}
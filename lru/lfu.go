@@ -1,3 +1,215 @@
 package lru
 
-// todo
+import (
+	"container/list"
+	"time"
+)
+
+/*
+	O(1)的LFU
+	外层链表按访问频次从小到大保存频次桶 每个桶内是相同频次的元素
+	桶内链表保证同频次下按最近最久未访问的顺序淘汰
+	字典保存key到元素的映射 保证O(1)的查询
+	为了防止历史热点长期占据缓存 访问次数达到一定数量后会把所有频次减半(老化)
+*/
+
+// agingFactor 每经过 agingFactor*元素个数 次访问 进行一次老化
+const agingFactor = 10
+
+type LFUCache struct {
+	maxBytes  int64                         // 最大内存限制
+	nbytes    int64                         // 当前已使用内存
+	freqs     *list.List                    // 频次桶链表 按频次从小到大
+	cache     map[string]*list.Element      // key对应桶内的元素
+	ops       int                           // 上次老化以来的访问次数
+	OnEvicted func(key string, value Value) // 回调函数 在淘汰数据时执行其他操作
+}
+
+// freqNode 频次桶
+type freqNode struct {
+	freq  int
+	items *list.List
+}
+
+type lfuEntry struct {
+	key    string
+	value  Value
+	expire time.Time     // 过期时间 零值表示永不过期
+	node   *list.Element // 所在的频次桶
+}
+
+func (e *lfuEntry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+func NewLFU(maxBytes int64, onEvicted func(string, Value)) *LFUCache {
+	return &LFUCache{
+		maxBytes:  maxBytes,
+		freqs:     list.New(),
+		cache:     make(map[string]*list.Element),
+		OnEvicted: onEvicted,
+	}
+}
+
+// Get 查找元素并增加其访问频次 已过期的元素视为未命中
+func (c *LFUCache) Get(key string) (value Value, ok bool) {
+	if ele, ok := c.cache[key]; ok {
+		kv := ele.Value.(*lfuEntry)
+		if kv.expired(time.Now()) {
+			c.removeElement(ele)
+			return nil, false
+		}
+		c.increment(ele)
+		c.age()
+		return kv.value, true
+	}
+	return
+}
+
+// Remove 删除访问频次最低的元素 频次相同时删除最久未访问的
+func (c *LFUCache) Remove() {
+	if ele := c.victim(nil); ele != nil {
+		c.removeElement(ele)
+	}
+}
+
+// Delete 删除指定的元素 返回元素是否存在
+func (c *LFUCache) Delete(key string) bool {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele)
+		return true
+	}
+	return false
+}
+
+// Add 添加或修改元素 元素永不过期
+func (c *LFUCache) Add(key string, value Value) (evicted []string, err error) {
+	return c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithExpire 添加或修改元素 元素在expire之后视为不存在 expire为零值表示永不过期
+// 与Cache一样先腾出空间再写入 元素本身超过maxBytes时返回ErrTooLarge
+func (c *LFUCache) AddWithExpire(key string, value Value, expire time.Time) (evicted []string, err error) {
+	size := int64(value.Len()) + int64(len(key))
+	ele, ok := c.cache[key]
+	if ok && (ele.Value.(*lfuEntry).expired(time.Now()) || (c.maxBytes != 0 && size > c.maxBytes)) {
+		evicted = append(evicted, key)
+		c.removeElement(ele)
+		ok = false
+	}
+	if c.maxBytes != 0 && size > c.maxBytes {
+		return evicted, ErrTooLarge
+	}
+	if ok {
+		// update 修改也算作一次访问
+		c.increment(ele)
+		ele = c.cache[key]
+		size -= int64(ele.Value.(*lfuEntry).value.Len()) + int64(len(key))
+	}
+	for c.maxBytes != 0 && c.nbytes+size > c.maxBytes {
+		victim := c.victim(ele)
+		evicted = append(evicted, victim.Value.(*lfuEntry).key)
+		c.removeElement(victim)
+	}
+	c.nbytes += size
+	if ok {
+		kv := ele.Value.(*lfuEntry)
+		kv.value = value
+		kv.expire = expire
+	} else {
+		// add 新元素的频次为1
+		node := c.freqs.Front()
+		if node == nil || node.Value.(*freqNode).freq != 1 {
+			node = c.freqs.PushFront(&freqNode{freq: 1, items: list.New()})
+		}
+		kv := &lfuEntry{key: key, value: value, expire: expire, node: node}
+		c.cache[key] = node.Value.(*freqNode).items.PushBack(kv)
+	}
+	c.age()
+	return evicted, nil
+}
+
+// victim 选出下一个要淘汰的元素 跳过正在修改的元素skip
+func (c *LFUCache) victim(skip *list.Element) *list.Element {
+	for node := c.freqs.Front(); node != nil; node = node.Next() {
+		for ele := node.Value.(*freqNode).items.Front(); ele != nil; ele = ele.Next() {
+			if ele != skip {
+				return ele
+			}
+		}
+	}
+	return nil
+}
+
+func (c *LFUCache) Len() int {
+	return len(c.cache)
+}
+
+func (c *LFUCache) Bytes() int64 {
+	return c.nbytes
+}
+
+// increment 把元素移动到频次+1的桶中
+func (c *LFUCache) increment(ele *list.Element) {
+	kv := ele.Value.(*lfuEntry)
+	cur := kv.node.Value.(*freqNode)
+	next := kv.node.Next()
+	if next == nil || next.Value.(*freqNode).freq != cur.freq+1 {
+		next = c.freqs.InsertAfter(&freqNode{freq: cur.freq + 1, items: list.New()}, kv.node)
+	}
+	c.move(ele, next)
+}
+
+// move 把元素从当前桶移动到node桶的队尾 当前桶为空时删除
+func (c *LFUCache) move(ele *list.Element, node *list.Element) {
+	kv := ele.Value.(*lfuEntry)
+	old := kv.node
+	old.Value.(*freqNode).items.Remove(ele)
+	if old.Value.(*freqNode).items.Len() == 0 {
+		c.freqs.Remove(old)
+	}
+	kv.node = node
+	c.cache[kv.key] = node.Value.(*freqNode).items.PushBack(kv)
+}
+
+func (c *LFUCache) removeElement(ele *list.Element) {
+	kv := ele.Value.(*lfuEntry)
+	node := kv.node
+	node.Value.(*freqNode).items.Remove(ele)
+	if node.Value.(*freqNode).items.Len() == 0 {
+		c.freqs.Remove(node)
+	}
+	delete(c.cache, kv.key)
+	c.nbytes -= int64(kv.value.Len()) + int64(len(kv.key))
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value)
+	}
+}
+
+// age 访问次数足够多时 把所有元素的频次减半
+// 摊还到每次访问上仍然是O(1)
+func (c *LFUCache) age() {
+	c.ops++
+	if c.ops < agingFactor*len(c.cache) {
+		return
+	}
+	c.ops = 0
+	var prev *list.Element
+	for node := c.freqs.Front(); node != nil; {
+		next := node.Next()
+		fn := node.Value.(*freqNode)
+		fn.freq /= 2
+		if fn.freq < 1 {
+			fn.freq = 1
+		}
+		// 减半后频次与前一个桶相同 合并到前一个桶
+		if prev != nil && prev.Value.(*freqNode).freq == fn.freq {
+			for ele := fn.items.Front(); ele != nil; ele = fn.items.Front() {
+				c.move(ele, prev)
+			}
+		} else {
+			prev = node
+		}
+		node = next
+	}
+}
@@ -0,0 +1,122 @@
+package lru
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestLFUCache_Get(t *testing.T) {
+	lfuCache := NewLFU(0, nil)
+	lfuCache.Add("name", Str("hylio"))
+	if v, ok := lfuCache.Get("name"); !ok || string(v.(Str)) != "hylio" {
+		t.Error("hit fail")
+	}
+	if _, ok := lfuCache.Get("age"); ok {
+		t.Error("miss fail")
+	}
+}
+
+func TestLFUCache_Remove(t *testing.T) {
+	k1, k2, k3 := "name", "age", "sex"
+	v1, v2, v3 := "hylio", "24", "M"
+	cap := len(k1 + k2 + v1 + v2)
+	lfuCache := NewLFU(int64(cap), nil)
+	lfuCache.Add(k1, Str(v1))
+	lfuCache.Add(k2, Str(v2))
+	// k1被访问过 频次更高 应该淘汰k2
+	lfuCache.Get(k1)
+	lfuCache.Add(k3, Str(v3))
+	if _, ok := lfuCache.Get(k1); !ok {
+		t.Error("hit k1 fail")
+	}
+	if _, ok := lfuCache.Get(k2); ok || lfuCache.Len() != 2 {
+		t.Error("remove k2 fail")
+	}
+}
+
+func TestLFUCache_Add(t *testing.T) {
+	k1 := "name"
+	v1 := "hylio"
+	v2 := "zh"
+	cap := len(k1 + v1)
+	lfuCache := NewLFU(int64(cap), nil)
+	lfuCache.Add(k1, Str(v1))
+	if _, ok := lfuCache.cache[k1]; !ok || lfuCache.nbytes != int64(cap) {
+		t.Error("hit k1 fail")
+	}
+	lfuCache.Add(k1, Str(v2))
+	if v, ok := lfuCache.Get(k1); !ok || string(v.(Str)) != v2 {
+		t.Error("update k1 fail")
+	}
+}
+
+func TestLFUOnEvicted(t *testing.T) {
+	del := make([]string, 0)
+	callback := func(key string, value Value) {
+		del = append(del, key)
+	}
+	k1, k2, k3 := "name", "age", "sex"
+	v1, v2, v3 := "hylio", "24", "male"
+	cap := len(k1 + k2 + v1 + v2)
+	lfuCache := NewLFU(int64(cap), callback)
+	lfuCache.Add(k1, Str(v1))
+	lfuCache.Add(k2, Str(v2))
+	lfuCache.Add(k3, Str(v3))
+	expect := []string{k1}
+
+	if !reflect.DeepEqual(expect, del) {
+		t.Fatalf("Call OnEvicted failed, expect keys equals to %s", expect)
+	}
+}
+
+func TestLFULimit(t *testing.T) {
+	k1, k2, k3 := "name", "age", "sex"
+	v1, v2, v3 := "hylio", "24", "malemalemalemale"
+	cap := 10
+	lfuCache := NewLFU(int64(cap), nil)
+	lfuCache.Add(k1, Str(v1))
+	lfuCache.Add(k2, Str(v2))
+	// 超过maxBytes的元素直接拒绝 不影响已有的元素
+	if _, err := lfuCache.Add(k3, Str(v3)); err != ErrTooLarge {
+		t.Errorf("expect ErrTooLarge, but got %v", err)
+	}
+	if _, ok := lfuCache.Get(k3); ok || lfuCache.Len() != 1 {
+		t.Error("limit fail")
+	}
+}
+
+func TestLFUExpire(t *testing.T) {
+	lfuCache := NewLFU(0, nil)
+	lfuCache.AddWithExpire("name", Str("hylio"), time.Now().Add(-time.Second))
+	if _, ok := lfuCache.Get("name"); ok || lfuCache.Len() != 0 {
+		t.Error("expired entry should miss")
+	}
+}
+
+func TestLFUAging(t *testing.T) {
+	lfuCache := NewLFU(0, nil)
+	lfuCache.Add("hot", Str("v"))
+	// Add和9次Get共10次访问 触发老化 频次从10减半
+	for i := 0; i < 9; i++ {
+		lfuCache.Get("hot")
+	}
+	if freq := lfuCache.cache["hot"].Value.(*lfuEntry).node.Value.(*freqNode).freq; freq != 5 {
+		t.Fatalf("aging failed, expect freq 5 but got %d", freq)
+	}
+	if lfuCache.freqs.Len() != 1 {
+		t.Fatalf("expect 1 frequency bucket but got %d", lfuCache.freqs.Len())
+	}
+}
+
+func TestLFUCache_Delete(t *testing.T) {
+	lfuCache := NewLFU(0, nil)
+	lfuCache.Add("name", Str("hylio"))
+	lfuCache.Get("name")
+	if !lfuCache.Delete("name") || lfuCache.Delete("age") {
+		t.Error("delete fail")
+	}
+	if _, ok := lfuCache.Get("name"); ok || lfuCache.Len() != 0 || lfuCache.freqs.Len() != 0 {
+		t.Error("name should be deleted")
+	}
+}
@@ -1,6 +1,11 @@
 package lru
 
-import "container/list"
+import (
+	"container/heap"
+	"container/list"
+	"errors"
+	"time"
+)
 
 /*
 	使用双向链表和字典结合的LRU
@@ -13,14 +18,51 @@ type Cache struct {
 	nbytes    int64                         // 当前已使用内存
 	ll        *list.List                    // 双向链表
 	cache     map[string]*list.Element      // map
+	expiries  expiryHeap                    // 会过期的元素 按过期时间排序
 	OnEvicted func(key string, value Value) // 回调函数 在淘汰数据时执行其他操作
 }
 
 type entry struct {
-	key   string
-	value Value
+	key    string
+	value  Value
+	expire time.Time // 过期时间 零值表示永不过期
+	index  int       // 在expiries中的下标 -1表示永不过期 不在堆中
 }
 
+// expired 判断元素在now时刻是否已经过期
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+// expiryHeap 按过期时间排序的小顶堆 回收过期元素时只需要查看堆顶
+type expiryHeap []*entry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].expire.Before(h[j].expire) }
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	kv := x.(*entry)
+	kv.index = len(*h)
+	*h = append(*h, kv)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	kv := old[len(old)-1]
+	old[len(old)-1] = nil
+	kv.index = -1
+	*h = old[:len(old)-1]
+	return kv
+}
+
+// ErrTooLarge 元素本身的大小超过了maxBytes 无法写入缓存
+var ErrTooLarge = errors.New("lru: value is larger than maxBytes")
+
 // Value 为了通用性 定义为interface 方法只包含Len 用于返回值所占的内存大小
 type Value interface {
 	Len() int
@@ -35,60 +77,174 @@ func New(maxBytes int64, onEvicted func(string, Value)) *Cache {
 	}
 }
 
+// Get 查找元素 已过期的元素视为未命中 并顺便删除
 func (c *Cache) Get(key string) (value Value, ok bool) {
 	if ele, ok := c.cache[key]; ok {
+		kv := ele.Value.(*entry)
+		if kv.expired(time.Now()) {
+			c.removeElement(ele)
+			return nil, false
+		}
 		// 最近访问过  放到队尾
 		c.ll.MoveToBack(ele)
-		kv := ele.Value.(*entry)
 		value = kv.value
 		return value, true
 	}
 	return
 }
 
+// Peek 查找元素 但不改变元素的访问顺序
+func (c *Cache) Peek(key string) (value Value, ok bool) {
+	if ele, ok := c.cache[key]; ok {
+		kv := ele.Value.(*entry)
+		if kv.expired(time.Now()) {
+			return nil, false
+		}
+		return kv.value, true
+	}
+	return
+}
+
+// Contains 判断元素是否存在且未过期 不改变元素的访问顺序
+func (c *Cache) Contains(key string) bool {
+	_, ok := c.Peek(key)
+	return ok
+}
+
+// Keys 按从最久未访问到最近访问的顺序返回所有未过期的key
+func (c *Cache) Keys() []string {
+	now := time.Now()
+	keys := make([]string, 0, c.ll.Len())
+	for ele := c.ll.Front(); ele != nil; ele = ele.Next() {
+		if kv := ele.Value.(*entry); !kv.expired(now) {
+			keys = append(keys, kv.key)
+		}
+	}
+	return keys
+}
+
+// Delete 删除指定的元素 返回元素是否存在
+func (c *Cache) Delete(key string) bool {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele)
+		return true
+	}
+	return false
+}
+
+// Clear 删除所有元素 每个元素都会触发OnEvicted
+func (c *Cache) Clear() {
+	for ele := c.ll.Front(); ele != nil; ele = c.ll.Front() {
+		c.removeElement(ele)
+	}
+}
+
 // Remove 删除掉最近最久未访问 即链表最前面的元素
 func (c *Cache) Remove() {
 	ele := c.ll.Front()
 	// 不加if nil 的判断的话  如果删完就会报错
 	if ele != nil {
-		kv := ele.Value.(*entry)
-		key, value := kv.key, kv.value
-		delete(c.cache, key)
-		// 计算内存时需要考虑字典占用的内存
-		c.nbytes -= int64(value.Len()) + int64(len(key))
-		if c.OnEvicted != nil {
-			c.OnEvicted(key, value)
-		}
-		c.ll.Remove(ele)
+		c.removeElement(ele)
 	}
 }
 
-// Add 添加或修改元素
-func (c *Cache) Add(key string, value Value) {
-	if ele, ok := c.cache[key]; ok {
-		// update
+// RemoveExpired 删除所有已过期的元素 返回删除的个数
+func (c *Cache) RemoveExpired() int {
+	return len(c.removeExpired(time.Now(), nil))
+}
+
+// removeExpired 删除在now时刻已过期的元素 被删除的key追加到evicted中返回
+// 只从堆顶开始删除 开销与过期元素的个数成正比 与缓存大小无关
+func (c *Cache) removeExpired(now time.Time, evicted []string) []string {
+	for len(c.expiries) > 0 && c.expiries[0].expired(now) {
+		kv := c.expiries[0]
+		evicted = append(evicted, kv.key)
+		c.removeElement(c.cache[kv.key])
+	}
+	return evicted
+}
+
+// setExpire 修改元素的过期时间 并维护expiries
+func (c *Cache) setExpire(kv *entry, expire time.Time) {
+	kv.expire = expire
+	switch {
+	case kv.index >= 0 && expire.IsZero():
+		heap.Remove(&c.expiries, kv.index)
+	case kv.index >= 0:
+		heap.Fix(&c.expiries, kv.index)
+	case !expire.IsZero():
+		heap.Push(&c.expiries, kv)
+	}
+}
+
+func (c *Cache) removeElement(ele *list.Element) {
+	kv := ele.Value.(*entry)
+	if kv.index >= 0 {
+		heap.Remove(&c.expiries, kv.index)
+	}
+	key, value := kv.key, kv.value
+	delete(c.cache, key)
+	// 计算内存时需要考虑字典占用的内存
+	c.nbytes -= int64(value.Len()) + int64(len(key))
+	if c.OnEvicted != nil {
+		c.OnEvicted(key, value)
+	}
+	c.ll.Remove(ele)
+}
+
+// Add 添加或修改元素 元素永不过期
+func (c *Cache) Add(key string, value Value) (evicted []string, err error) {
+	return c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithExpire 添加或修改元素 元素在expire之后视为不存在 expire为零值表示永不过期
+// 会先淘汰元素腾出空间再写入 因此已使用内存任何时刻都不会超过maxBytes
+// 返回本次被淘汰的key 元素本身超过maxBytes时不写入并返回ErrTooLarge
+func (c *Cache) AddWithExpire(key string, value Value, expire time.Time) (evicted []string, err error) {
+	now := time.Now()
+	size := int64(value.Len()) + int64(len(key))
+	ele, ok := c.cache[key]
+	if ok && (ele.Value.(*entry).expired(now) || (c.maxBytes != 0 && size > c.maxBytes)) {
+		// 旧值已过期 或者新值无法写入时 删掉旧值 避免之后读到过期的数据
+		evicted = append(evicted, key)
+		c.removeElement(ele)
+		ok = false
+	}
+	if c.maxBytes != 0 && size > c.maxBytes {
+		return evicted, ErrTooLarge
+	}
+	if ok {
+		// update 只需要为新旧值的差额腾出空间
 		c.ll.MoveToBack(ele)
+		size -= int64(ele.Value.(*entry).value.Len()) + int64(len(key))
+	}
+	// 内存不足时 优先回收已过期的元素 再淘汰最近最久未访问的元素
+	// 正在修改的元素已经移到队尾 不会被淘汰
+	if c.maxBytes != 0 && c.nbytes+size > c.maxBytes {
+		evicted = c.removeExpired(now, evicted)
+	}
+	for c.maxBytes != 0 && c.nbytes+size > c.maxBytes {
+		front := c.ll.Front()
+		evicted = append(evicted, front.Value.(*entry).key)
+		c.removeElement(front)
+	}
+	c.nbytes += size
+	if ok {
 		kv := ele.Value.(*entry)
-		c.nbytes += int64(value.Len()) - int64(kv.value.Len())
-		//for c.maxBytes != 0 && c.maxBytes < c.nbytes {
-		//	c.Remove()
-		//}
 		kv.value = value
+		c.setExpire(kv, expire)
 	} else {
-		// add
-		c.nbytes += int64(value.Len()) + int64(len(key))
-		//for c.maxBytes != 0 && c.maxBytes < c.nbytes {
-		//	c.Remove()
-		//}
-		ele := c.ll.PushBack(&entry{key: key, value: value})
-		c.cache[key] = ele
-	}
-	// TODO: 会先超内存再删
-	for c.maxBytes != 0 && c.maxBytes < c.nbytes {
-		c.Remove()
+		kv := &entry{key: key, value: value, index: -1}
+		c.setExpire(kv, expire)
+		c.cache[key] = c.ll.PushBack(kv)
 	}
+	return evicted, nil
 }
 
 func (c *Cache) Len() int {
 	return c.ll.Len()
 }
+
+func (c *Cache) Bytes() int64 {
+	return c.nbytes
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 )
 
 type Str string
@@ -82,8 +83,170 @@ func TestLimit(t *testing.T) {
 	lruCache := New(int64(cap), nil)
 	lruCache.Add(k1, Str(v1))
 	lruCache.Add(k2, Str(v2))
-	lruCache.Add(k3, Str(v3))
-	if _, ok := lruCache.Get(k3); ok || lruCache.Len() != 0 {
+	// 超过maxBytes的元素直接拒绝 不会清空已有的元素
+	if _, err := lruCache.Add(k3, Str(v3)); err != ErrTooLarge {
+		t.Errorf("expect ErrTooLarge, but got %v", err)
+	}
+	if _, ok := lruCache.Get(k3); ok || lruCache.Len() != 1 {
 		t.Error("limit fail")
 	}
+	if _, ok := lruCache.Get(k2); !ok {
+		t.Error("k2 should not be evicted")
+	}
+}
+
+func TestEvictBeforeAdd(t *testing.T) {
+	k1, k2, k3 := "name", "age", "sex"
+	v1, v2, v3 := "hylio", "24", "male"
+	cap := len(k1 + k2 + v1 + v2)
+	var lruCache *Cache
+	lruCache = New(int64(cap), func(key string, value Value) {
+		// 淘汰发生在写入之前 任何时刻都不应该超出内存限制
+		if lruCache.nbytes > int64(cap) {
+			t.Errorf("nbytes %d exceed %d when evicting %s", lruCache.nbytes, cap, key)
+		}
+	})
+	lruCache.Add(k1, Str(v1))
+	lruCache.Add(k2, Str(v2))
+	evicted, err := lruCache.Add(k3, Str(v3))
+	if err != nil || !reflect.DeepEqual(evicted, []string{k1}) {
+		t.Fatalf("expect evicted %v, but got %v, err %v", []string{k1}, evicted, err)
+	}
+	// 修改元素时 只为差额腾出空间 且不会淘汰自己
+	evicted, err = lruCache.Add(k3, Str(v3+v3))
+	if err != nil || !reflect.DeepEqual(evicted, []string{k2}) {
+		t.Fatalf("expect evicted %v, but got %v, err %v", []string{k2}, evicted, err)
+	}
+	if v, ok := lruCache.Get(k3); !ok || string(v.(Str)) != v3+v3 {
+		t.Error("update k3 fail")
+	}
+}
+
+func TestCache_Expire(t *testing.T) {
+	lruCache := New(0, nil)
+	lruCache.AddWithExpire("name", Str("hylio"), time.Now().Add(-time.Second))
+	lruCache.AddWithExpire("age", Str("24"), time.Now().Add(time.Hour))
+	if _, ok := lruCache.Get("name"); ok || lruCache.Len() != 1 {
+		t.Error("expired entry should miss")
+	}
+	if v, ok := lruCache.Get("age"); !ok || string(v.(Str)) != "24" {
+		t.Error("hit age fail")
+	}
+}
+
+func TestCache_KeysSkipExpired(t *testing.T) {
+	lruCache := New(0, nil)
+	lruCache.AddWithExpire("name", Str("hylio"), time.Now().Add(-time.Second))
+	lruCache.AddWithExpire("age", Str("24"), time.Now().Add(time.Hour))
+	lruCache.Add("sex", Str("M"))
+	// 过期的元素还没有被删除 但和Peek Contains一样视为不存在
+	if lruCache.Contains("name") || !reflect.DeepEqual(lruCache.Keys(), []string{"age", "sex"}) {
+		t.Fatalf("expired key should be skipped, got %v", lruCache.Keys())
+	}
+}
+
+func TestCache_RemoveExpired(t *testing.T) {
+	k1, k2, k3 := "name", "age", "sex"
+	v1, v2, v3 := "hylio", "24", "M"
+	cap := len(k1 + k2 + v1 + v2)
+	lruCache := New(int64(cap), nil)
+	lruCache.Add(k1, Str(v1))
+	lruCache.AddWithExpire(k2, Str(v2), time.Now().Add(-time.Second))
+	// 内存不足时 应该先回收过期的k2 而不是最久未访问的k1
+	lruCache.Add(k3, Str(v3))
+	if _, ok := lruCache.Get(k1); !ok {
+		t.Error("k1 should not be evicted")
+	}
+	if lruCache.nbytes != int64(len(k1+k3+v1+v3)) {
+		t.Errorf("expired bytes not reclaimed, nbytes = %d", lruCache.nbytes)
+	}
+}
+
+func TestCache_RemoveExpiredStaggered(t *testing.T) {
+	lruCache := New(0, nil)
+	now := time.Now()
+	lruCache.AddWithExpire("k1", Str("1"), now.Add(-3*time.Second))
+	lruCache.AddWithExpire("k2", Str("2"), now.Add(time.Hour))
+	lruCache.AddWithExpire("k3", Str("3"), now.Add(-time.Second))
+	lruCache.AddWithExpire("k4", Str("4"), now.Add(-2*time.Second))
+	// 修改过期时间后 k4不再过期 k2已经过期
+	lruCache.Add("k4", Str("4"))
+	lruCache.AddWithExpire("k2", Str("2"), now.Add(-time.Second))
+	if n := lruCache.RemoveExpired(); n != 3 {
+		t.Fatalf("expect 3 expired entries, got %d", n)
+	}
+	if !reflect.DeepEqual(lruCache.Keys(), []string{"k4"}) || len(lruCache.expiries) != 0 {
+		t.Fatalf("only k4 should be left, got %v", lruCache.Keys())
+	}
+}
+
+// BenchmarkAddStaggeredExpire 缓存已满且元素的过期时间各不相同时 Add不应该扫描整个缓存
+func BenchmarkAddStaggeredExpire(b *testing.B) {
+	const n = 100000
+	lruCache := New(int64(n*len("k000000v")), nil)
+	now := time.Now()
+	for i := 0; i < n; i++ {
+		lruCache.AddWithExpire(fmt.Sprintf("k%06d", i), Str("v"), now.Add(time.Duration(i)*time.Millisecond))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lruCache.AddWithExpire(fmt.Sprintf("k%06d", n+i%n), Str("v"), now.Add(time.Duration(i)*time.Millisecond))
+	}
+}
+
+func TestCache_Delete(t *testing.T) {
+	del := make([]string, 0)
+	lruCache := New(0, func(key string, value Value) {
+		del = append(del, key)
+	})
+	lruCache.Add("name", Str("hylio"))
+	lruCache.Add("age", Str("24"))
+	if !lruCache.Delete("name") || lruCache.Delete("sex") {
+		t.Error("delete fail")
+	}
+	if _, ok := lruCache.Get("name"); ok || lruCache.Len() != 1 || lruCache.nbytes != int64(len("age24")) {
+		t.Error("name should be deleted")
+	}
+	if !reflect.DeepEqual(del, []string{"name"}) {
+		t.Fatalf("Call OnEvicted failed, expect keys equals to %s", []string{"name"})
+	}
+}
+
+func TestCache_Peek(t *testing.T) {
+	k1, k2, k3 := "name", "age", "sex"
+	v1, v2, v3 := "hylio", "24", "M"
+	cap := len(k1 + k2 + v1 + v2)
+	lruCache := New(int64(cap), nil)
+	lruCache.Add(k1, Str(v1))
+	lruCache.Add(k2, Str(v2))
+	// Peek和Contains不改变访问顺序 k1仍然是最久未访问的
+	if v, ok := lruCache.Peek(k1); !ok || string(v.(Str)) != v1 || !lruCache.Contains(k1) {
+		t.Error("peek k1 fail")
+	}
+	if !reflect.DeepEqual(lruCache.Keys(), []string{k1, k2}) {
+		t.Fatalf("expect keys %v, but got %v", []string{k1, k2}, lruCache.Keys())
+	}
+	lruCache.Add(k3, Str(v3))
+	if lruCache.Contains(k1) {
+		t.Error("k1 should be evicted")
+	}
+	if !reflect.DeepEqual(lruCache.Keys(), []string{k2, k3}) {
+		t.Fatalf("expect keys %v, but got %v", []string{k2, k3}, lruCache.Keys())
+	}
+}
+
+func TestCache_Clear(t *testing.T) {
+	del := make([]string, 0)
+	lruCache := New(0, func(key string, value Value) {
+		del = append(del, key)
+	})
+	lruCache.Add("name", Str("hylio"))
+	lruCache.Add("age", Str("24"))
+	lruCache.Clear()
+	if lruCache.Len() != 0 || lruCache.nbytes != 0 {
+		t.Error("clear fail")
+	}
+	if !reflect.DeepEqual(del, []string{"name", "age"}) {
+		t.Fatalf("Call OnEvicted failed, expect keys equals to %s", []string{"name", "age"})
+	}
 }
@@ -0,0 +1,48 @@
+package lru
+
+import "time"
+
+// Policy 缓存淘汰策略 cache模块只依赖这个接口
+// 因此不同的Group可以使用不同的淘汰算法
+type Policy interface {
+	// Add 添加或修改元素 元素永不过期
+	// 返回本次被淘汰的key 元素本身超过内存限制时返回ErrTooLarge
+	Add(key string, value Value) (evicted []string, err error)
+	// AddWithExpire 添加或修改元素 expire为零值表示永不过期
+	AddWithExpire(key string, value Value, expire time.Time) (evicted []string, err error)
+	// Get 查找元素 已过期的元素视为未命中
+	Get(key string) (value Value, ok bool)
+	// Remove 按照策略淘汰一个元素
+	Remove()
+	// Delete 删除指定的元素 返回元素是否存在
+	Delete(key string) bool
+	// Len 元素个数
+	Len() int
+	// Bytes 当前已使用内存
+	Bytes() int64
+}
+
+// NewPolicy 根据内存限制和淘汰回调构造一个淘汰策略
+type NewPolicy func(maxBytes int64, onEvicted func(key string, value Value)) Policy
+
+// LRU 最近最久未使用淘汰策略
+func LRU(maxBytes int64, onEvicted func(key string, value Value)) Policy {
+	return New(maxBytes, onEvicted)
+}
+
+// LFU 最不经常使用淘汰策略
+func LFU(maxBytes int64, onEvicted func(key string, value Value)) Policy {
+	return NewLFU(maxBytes, onEvicted)
+}
+
+// ARC 自适应替换淘汰策略
+func ARC(maxBytes int64, onEvicted func(key string, value Value)) Policy {
+	return NewARC(maxBytes, onEvicted)
+}
+
+// 测试各个算法是否实现了Policy接口
+var (
+	_ Policy = (*Cache)(nil)
+	_ Policy = (*LFUCache)(nil)
+	_ Policy = (*ARCCache)(nil)
+)
@@ -0,0 +1,107 @@
+package hyliocache
+
+import (
+	"crypto/tls"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"time"
+)
+
+// options 提供Server和Client的配置 零值的字段使用默认值
+
+const (
+	defaultServiceName = "_hyliocache"
+	defaultLeaseTTL    = 5 // 秒
+)
+
+var defaultEtcdEndpoints = []string{"localhost:2379"}
+
+const defaultEtcdDialTimeout = 5 * time.Second
+
+// defaultMaxMsgSize 单个grpc消息的默认上限 grpc自身的默认值只有4MB Set和BatchGet的value很容易超过
+const defaultMaxMsgSize = 64 << 20
+
+// EtcdOptions etcd的连接配置
+type EtcdOptions struct {
+	Endpoints   []string      // etcd地址 默认为localhost:2379
+	DialTimeout time.Duration // 连接etcd的超时时间 默认为5s
+	TLS         *tls.Config   // 为nil时不使用tls
+	Username    string        // 为空时不使用认证
+	Password    string
+}
+
+// config 转换为clientv3.Config
+func (o EtcdOptions) config() clientv3.Config {
+	cfg := clientv3.Config{
+		Endpoints:   o.Endpoints,
+		DialTimeout: o.DialTimeout,
+		TLS:         o.TLS,
+		Username:    o.Username,
+		Password:    o.Password,
+	}
+	if len(cfg.Endpoints) == 0 {
+		cfg.Endpoints = defaultEtcdEndpoints
+	}
+	if cfg.DialTimeout == 0 {
+		cfg.DialTimeout = defaultEtcdDialTimeout
+	}
+	return cfg
+}
+
+// ServerOptions Server的配置
+type ServerOptions struct {
+	Etcd EtcdOptions
+	// ServiceName 注册到etcd的服务名 共用一个etcd的多个集群需要使用不同的服务名 默认为_hyliocache
+	ServiceName string
+	// LeaseTTL 注册服务时租约的秒数 节点异常退出后最多经过LeaseTTL秒被其他节点发现 默认为5
+	LeaseTTL int64
+	// StaticPeers 为true时不监听etcd中的节点变化 只使用Set配置的节点
+	StaticPeers bool
+	// Weight 本节点在一致性哈希中的权重 随服务一起注册到etcd 内存更大的节点应该设置更大的权重 默认为1
+	Weight int
+	// LoadEpsilon 有界负载的一致性哈希 发往某个节点的进行中请求超过(1+LoadEpsilon)倍平均值时
+	// PickPeer沿哈希环选择下一个节点 0表示不启用
+	LoadEpsilon float64
+	// MaxMsgSize 收发单个grpc消息的最大字节数 限制Set的value和一次BatchGet返回的总大小 默认为64MB
+	// Get通过流式rpc传输 不受这个限制
+	MaxMsgSize int
+}
+
+func (o ServerOptions) withDefaults() ServerOptions {
+	if o.ServiceName == "" {
+		o.ServiceName = defaultServiceName
+	}
+	if o.LeaseTTL <= 0 {
+		o.LeaseTTL = defaultLeaseTTL
+	}
+	if o.Weight < 1 {
+		o.Weight = 1
+	}
+	if o.MaxMsgSize <= 0 {
+		o.MaxMsgSize = defaultMaxMsgSize
+	}
+	return o
+}
+
+// clientOptions Server为远端节点创建的Client与Server使用相同的etcd、服务名和消息大小上限
+func (o ServerOptions) clientOptions() ClientOptions {
+	return ClientOptions{Etcd: o.Etcd, ServiceName: o.ServiceName, MaxMsgSize: o.MaxMsgSize}
+}
+
+// ClientOptions Client的配置
+type ClientOptions struct {
+	Etcd EtcdOptions
+	// ServiceName 通过etcd发现服务时使用的服务名 需要与远端节点的ServerOptions.ServiceName一致 默认为_hyliocache
+	ServiceName string
+	// MaxMsgSize 收发单个grpc消息的最大字节数 需要和远端节点的ServerOptions.MaxMsgSize一致 默认为64MB
+	MaxMsgSize int
+}
+
+func (o ClientOptions) withDefaults() ClientOptions {
+	if o.ServiceName == "" {
+		o.ServiceName = defaultServiceName
+	}
+	if o.MaxMsgSize <= 0 {
+		o.MaxMsgSize = defaultMaxMsgSize
+	}
+	return o
+}
@@ -0,0 +1,30 @@
+package hyliocache
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestOptionsDefaults(t *testing.T) {
+	cfg := EtcdOptions{}.config()
+	if !reflect.DeepEqual(cfg.Endpoints, []string{"localhost:2379"}) || cfg.DialTimeout != 5*time.Second {
+		t.Fatalf("unexpected default etcd config %v", cfg)
+	}
+	cfg = EtcdOptions{Endpoints: []string{"etcd:2379"}, DialTimeout: time.Second, Username: "hylio"}.config()
+	if !reflect.DeepEqual(cfg.Endpoints, []string{"etcd:2379"}) || cfg.DialTimeout != time.Second || cfg.Username != "hylio" {
+		t.Fatalf("etcd options should be kept, got %v", cfg)
+	}
+
+	p := NewServerWithOptions("", ServerOptions{ServiceName: "cluster_a"})
+	if p.opts.ServiceName != "cluster_a" || p.opts.LeaseTTL != 5 || p.opts.MaxMsgSize != 64<<20 {
+		t.Fatalf("unexpected server options %v", p.opts)
+	}
+	p.Set("127.0.0.1:8001")
+	if c := p.clients["127.0.0.1:8001"]; c.opts.ServiceName != "cluster_a" || c.opts.MaxMsgSize != p.opts.MaxMsgSize {
+		t.Fatal("clients should use the server's service name and message size")
+	}
+	if c := NewClient("127.0.0.1:8001"); c.opts.ServiceName != "_hyliocache" {
+		t.Fatal("client should use the default service name")
+	}
+}
@@ -1,14 +1,26 @@
 package hyliocache
 
-import pb "github.com/hylio/hyliocache/hyliocachepb"
+import (
+	"context"
+	pb "github.com/hylio/hyliocache/hyliocachepb"
+)
 
 // PeerPicker 保证了获取远端分布式节点的能力 用Server实现了这个接口
 type PeerPicker interface {
-	// PickPeer 根据key选择远端节点
+	// PickPeer 根据key选择处理读请求的远端节点 可能因为负载过高而不是key所在的节点
 	PickPeer(key string) (peer PeerGetter, ok bool)
+	// PickOwner 返回key所在的远端节点 不考虑负载 Set和Remove需要发给key所在的节点
+	PickOwner(key string) (peer PeerGetter, ok bool)
 }
 
 // PeerGetter 保证了可以获取缓存的能力 用Client实现了这个接口
+// ctx的超时和取消会通过grpc传递给远端节点
 type PeerGetter interface {
-	Get(in *pb.Request) ([]byte, error)
+	Get(ctx context.Context, in *pb.Request) ([]byte, error)
+	// Delete 删除远端节点上的缓存
+	Delete(ctx context.Context, in *pb.Request) error
+	// Set 把缓存写入远端节点
+	Set(ctx context.Context, in *pb.SetRequest) error
+	// BatchGet 通过一次rpc从远端节点获取多个key 每个key的结果单独返回
+	BatchGet(ctx context.Context, in *pb.BatchRequest) (*pb.BatchResponse, error)
 }
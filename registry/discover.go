@@ -7,10 +7,12 @@ import (
 )
 
 // EtcdDial 使用etcd解析器创建一个gRPC客户端连接，该连接将连接到名为service的服务。
-func EtcdDial(c *clientv3.Client, service string) (*grpc.ClientConn, error) {
+// opts会追加在默认的连接选项之后。
+func EtcdDial(c *clientv3.Client, service string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
 	etcdResolver, err := resolver.NewBuilder(c)
 	if err != nil {
 		return nil, err
 	}
-	return grpc.Dial("etcd:///"+service, grpc.WithResolvers(etcdResolver), grpc.WithInsecure())
+	opts = append([]grpc.DialOption{grpc.WithResolvers(etcdResolver), grpc.WithInsecure()}, opts...)
+	return grpc.Dial("etcd:///"+service, opts...)
 }
@@ -18,33 +18,54 @@ var (
 	}
 )
 
-// etcdAdd 添加一对kv到etcd
-func etcdAdd(c *clientv3.Client, lid clientv3.LeaseID, service string, addr string) error {
+// requestTimeout 每个etcd请求的超时时间 etcd不可用时注册和注销不会一直阻塞
+const requestTimeout = 5 * time.Second
+
+// etcdAdd 添加一对kv到etcd metadata会和地址一起保存 其他节点发现服务时可以读取
+func etcdAdd(c *clientv3.Client, lid clientv3.LeaseID, service string, addr string, metadata interface{}) error {
 	em, err := endpoints.NewManager(c, service)
 	if err != nil {
 		return err
 	}
-	return em.AddEndpoint(c.Ctx(), service+"/"+addr, endpoints.Endpoint{Addr: addr}, clientv3.WithLease(lid))
+	ctx, cancel := context.WithTimeout(c.Ctx(), requestTimeout)
+	defer cancel()
+	return em.AddEndpoint(ctx, service+"/"+addr, endpoints.Endpoint{Addr: addr, Metadata: metadata}, clientv3.WithLease(lid))
+}
+
+// revoke 撤销租约 服务立即从etcd中删除 不必等到租约过期
+func revoke(c *clientv3.Client, lid clientv3.LeaseID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	_, err := c.Revoke(ctx, lid)
+	return err
 }
 
-// Registry 注册一个服务到etcd
+// Registry 使用默认配置注册一个服务到etcd 租约为5秒
 func Registry(service, addr string, stop chan error) error {
+	return RegistryWithConfig(defaultEtcdConfig, 5, service, addr, nil, stop)
+}
+
+// RegistryWithConfig 注册一个服务到etcd 阻塞直到stop收到信号或者租约失效
+// 收到stop信号时撤销租约 其他节点会立即发现服务下线 ttl为租约的秒数 metadata会被编码为json保存
+func RegistryWithConfig(cfg clientv3.Config, ttl int64, service, addr string, metadata interface{}, stop chan error) error {
 	// 创建etcd client
-	cli, err := clientv3.New(defaultEtcdConfig)
+	cli, err := clientv3.New(cfg)
 	if err != nil {
 		return fmt.Errorf("create etcd client failed: %v", err)
 	}
 	defer cli.Close()
 
 	// 创建一个租约
-	resp, err := cli.Grant(context.Background(), 5)
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	resp, err := cli.Grant(ctx, ttl)
+	cancel()
 	if err != nil {
 		return fmt.Errorf("create etcd lease failed: %v", err)
 	}
 	leaseid := resp.ID
 
 	// 服务注册
-	err = etcdAdd(cli, leaseid, service, addr)
+	err = etcdAdd(cli, leaseid, service, addr, metadata)
 	if err != nil {
 		return fmt.Errorf("add etcd failed: %v", err)
 	}
@@ -63,6 +84,9 @@ func Registry(service, addr string, stop chan error) error {
 			if err != nil {
 				log.Println(err)
 			}
+			if rerr := revoke(cli, leaseid); rerr != nil {
+				return fmt.Errorf("revoke etcd lease failed: %v", rerr)
+			}
 			return err
 		case <-cli.Ctx().Done():
 			// 服务结束
@@ -71,8 +95,7 @@ func Registry(service, addr string, stop chan error) error {
 			// keep alive 失效
 			if !ok {
 				log.Println("keep alive lose")
-				_, err := cli.Revoke(context.Background(), leaseid)
-				return err
+				return revoke(cli, leaseid)
 			}
 		}
 	}
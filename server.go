@@ -2,6 +2,7 @@ package hyliocache
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/golang/protobuf/proto"
@@ -9,12 +10,16 @@ import (
 	pb "github.com/hylio/hyliocache/hyliocachepb"
 	"github.com/hylio/hyliocache/registry"
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/naming/endpoints"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"log"
 	"net"
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -22,34 +27,53 @@ import (
 // peer节点之间可以通过server来获取其他节点的缓存
 
 const (
-	defaultAddr        = "127.0.0.1:4396"
-	defaultReplicas    = 50
+	defaultAddr     = "127.0.0.1:4396"
+	defaultReplicas = 50
 )
 
-var (
-	defaultEtcdConfig = clientv3.Config{
-		Endpoints:   []string{"localhost:2379"},
-		DialTimeout: 5 * time.Second,
-	}
+// deregisterTimeout Stop等待从etcd注销的最长时间 超过后继续停止服务
+var deregisterTimeout = 5 * time.Second
+
+// 重新监听etcd的退避间隔
+const (
+	watchMinBackoff = 100 * time.Millisecond
+	watchMaxBackoff = 10 * time.Second
 )
 
 // Server 实现了服务端功能
 type Server struct {
 	pb.UnimplementedGroupCacheServer
-	addr        string // 服务地址 like "http://localhost:8080"
-	mu          sync.Mutex
-	peers       *consistenthash.Map // 一致性哈希 选择节点
-	clients     map[string]*Client  // 每个节点对应的client
-	stopSignal  chan error          // 通知etcd 服务停止
-	status      bool
+	addr       string // 服务地址 like "localhost:8080" 和节点地址一样不带scheme
+	mu         sync.Mutex
+	peers      *consistenthash.Map // 一致性哈希 选择节点
+	clients    map[string]*Client  // 每个节点对应的client
+	opts       ServerOptions
+	etcd       *clientv3.Client // 所有Client共享的etcd client 懒创建
+	etcdMu     sync.Mutex
+	stopSignal chan error    // 通知etcd 服务停止
+	registered chan struct{} // 从etcd注销后关闭
+	grpcServer *grpc.Server
+	stopWatch  context.CancelFunc // 停止监听etcd中的节点变化
+	watchDone  chan struct{}      // 监听退出后关闭
+	status     bool
+	inflight   int64 // 发往所有远端节点的进行中rpc个数 由Client维护
+	// register 把服务注册到etcd 阻塞直到stopSignal收到信号 默认为registry.RegistryWithConfig 测试时替换
+	register func(cfg clientv3.Config, ttl int64, service, addr string, metadata interface{}, stop chan error) error
 }
 
 func NewServer(addr string) *Server {
+	return NewServerWithOptions(addr, ServerOptions{})
+}
+
+// NewServerWithOptions 使用指定的etcd配置和服务名创建Server
+func NewServerWithOptions(addr string, opts ServerOptions) *Server {
 	if addr == "" {
 		addr = defaultAddr
 	}
 	return &Server{
-		addr:        addr,
+		addr:     addr,
+		opts:     opts.withDefaults(),
+		register: registry.RegistryWithConfig,
 	}
 }
 
@@ -65,8 +89,13 @@ func (p *Server) Get(ctx context.Context, in *pb.Request) (*pb.Response, error)
 	if g == nil {
 		return resp, fmt.Errorf("group not found")
 	}
-	view, err := g.Get(key)
+	// ctx带有调用方通过grpc传递过来的超时
+	view, err := g.getForPeer(ctx, key)
 	if err != nil {
+		// 调用方据此区分数据不存在和临时故障
+		if errors.Is(err, ErrNotFound) {
+			return resp, status.Error(codes.NotFound, err.Error())
+		}
 		return resp, err
 	}
 	resp.Value = view.ByteSlice()
@@ -74,6 +103,99 @@ func (p *Server) Get(ctx context.Context, in *pb.Request) (*pb.Response, error)
 	return resp, nil
 }
 
+// Delete 删除本节点上的缓存 由其他节点在Group.Remove时调用
+func (p *Server) Delete(ctx context.Context, in *pb.Request) (*pb.DeleteResponse, error) {
+	group, key := in.GetGroup(), in.GetKey()
+	resp := &pb.DeleteResponse{}
+
+	log.Printf("[hyliocache_svr %s] Receive RPC delete - (%s)/(%s)", p.addr, group, key)
+	if key == "" {
+		return resp, fmt.Errorf("key is required")
+	}
+	g := GetGroup(group)
+	if g == nil {
+		return resp, fmt.Errorf("group not found")
+	}
+	resp.Deleted = g.removeLocally(key)
+	return resp, nil
+}
+
+// GetStream 以流的方式返回本节点上的缓存 value会被拆成多个Chunk 不受grpc消息大小的限制
+func (p *Server) GetStream(in *pb.Request, stream pb.GroupCache_GetStreamServer) error {
+	group, key := in.GetGroup(), in.GetKey()
+
+	log.Printf("[hyliocache_svr %s] Receive RPC stream request - (%s)/(%s)", p.addr, group, key)
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+	g := GetGroup(group)
+	if g == nil {
+		return fmt.Errorf("group not found")
+	}
+	view, err := g.getForPeer(stream.Context(), key)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			return status.Error(codes.NotFound, err.Error())
+		}
+		return err
+	}
+	return sendChunks(view.b, stream.Send)
+}
+
+// BatchGet 批量获取本节点上的缓存 由其他节点在Group.GetMany时调用
+func (p *Server) BatchGet(ctx context.Context, in *pb.BatchRequest) (*pb.BatchResponse, error) {
+	group, keys := in.GetGroup(), in.GetKeys()
+	resp := &pb.BatchResponse{}
+
+	log.Printf("[hyliocache_svr %s] Receive RPC batch get - (%s)/(%d keys)", p.addr, group, len(keys))
+	g := GetGroup(group)
+	if g == nil {
+		return resp, fmt.Errorf("group not found")
+	}
+	values, errs := g.getManyForPeer(ctx, keys)
+	resp.Results = make([]*pb.BatchResult, 0, len(values)+len(errs))
+	for key, view := range values {
+		resp.Results = append(resp.Results, &pb.BatchResult{Key: key, Value: view.ByteSlice()})
+	}
+	for key, err := range errs {
+		r := &pb.BatchResult{Key: key}
+		if errors.Is(err, ErrNotFound) {
+			r.NotFound = true
+		} else {
+			r.Error = err.Error()
+		}
+		resp.Results = append(resp.Results, r)
+	}
+	return resp, nil
+}
+
+// rpcServer 把Server注册为grpc服务
+// Server.Set已经用于配置远端节点 因此Set rpc由rpcServer实现 其余rpc直接使用Server的方法
+type rpcServer struct {
+	*Server
+}
+
+// Set 把value写入本节点的缓存 由其他节点在Group.Set时调用
+func (s rpcServer) Set(ctx context.Context, in *pb.SetRequest) (*pb.SetResponse, error) {
+	group, key := in.GetGroup(), in.GetKey()
+	resp := &pb.SetResponse{}
+
+	log.Printf("[hyliocache_svr %s] Receive RPC set - (%s)/(%s)", s.addr, group, key)
+	if key == "" {
+		return resp, fmt.Errorf("key is required")
+	}
+	g := GetGroup(group)
+	if g == nil {
+		return resp, fmt.Errorf("group not found")
+	}
+	// 发起Set的节点已经更新了自己的过滤器 本节点的过滤器也要加入这个key
+	if g.filter != nil {
+		g.filter.Add(key)
+	}
+	g.populateCache(key, ByteView{b: in.GetValue()}, &g.mainCache)
+	return resp, nil
+}
+
 // Start 启动服务
 func (p *Server) Start() error {
 	p.mu.Lock()
@@ -84,39 +206,71 @@ func (p *Server) Start() error {
 	fmt.Println("[server] start begins")
 	// 设置服务状态 添加报错通道
 	p.status = true
-	p.stopSignal = make(chan error)
+	// 有缓冲 Stop通知registry时不会阻塞 registry还在注册时也能在之后收到
+	p.stopSignal = make(chan error, 1)
 
-	// 初始化tcp socket
-	//port := strings.Split(p.addr, ":")[1]
-	var port string
-	if strings.HasPrefix(p.addr, "http://") {
-		port = strings.Split(p.addr[7:], ":")[1]
-	} else {
-		fmt.Println(p.addr)
-		port = strings.Split(p.addr, ":")[1]
+	// 地址会注册到etcd 其他节点通过Set或监听etcd获取 必须能通过CheckAddr
+	if !CheckAddr(p.addr) {
+		p.status = false
+		p.mu.Unlock()
+		return fmt.Errorf("invalid server addr %s", p.addr)
 	}
+	// 初始化tcp socket
+	port := strings.Split(p.addr, ":")[1]
 	lis, err := net.Listen("tcp", ":"+port)
 	if err != nil {
+		p.status = false
+		p.mu.Unlock()
 		return fmt.Errorf("failed to listen: %v", err)
 	}
 	fmt.Println("[server] tcp done.")
 	// 注册rpc服务到grpc
-	grpcServer := grpc.NewServer()
-	pb.RegisterGroupCacheServer(grpcServer, p)
+	grpcServer := grpc.NewServer(
+		grpc.MaxRecvMsgSize(p.opts.MaxMsgSize),
+		grpc.MaxSendMsgSize(p.opts.MaxMsgSize),
+	)
+	pb.RegisterGroupCacheServer(grpcServer, rpcServer{p})
+	p.grpcServer = grpcServer
+	p.registered = make(chan struct{})
 
 	// 注册到etcd
-	go func() {
-		err := registry.Registry("_hyliocache", p.addr, p.stopSignal)
+	go func(registered chan struct{}) {
+		metadata := map[string]interface{}{"weight": p.opts.Weight}
+		err := p.register(p.opts.Etcd.config(), p.opts.LeaseTTL, p.opts.ServiceName, p.addr, metadata, p.stopSignal)
+		p.mu.Lock()
+		stopping := !p.status
+		p.mu.Unlock()
+		// 通过Stop停止时由GracefulStop关闭tcp socket 撤销租约失败时租约过期后服务也会从etcd中删除
+		if stopping {
+			close(registered)
+			if err != nil {
+				log.Printf("[%s] Failed to revoke service: %v", p.addr, err)
+				return
+			}
+			log.Printf("[%s] Revoke service", p.addr)
+			return
+		}
 		if err != nil {
 			log.Fatalf(err.Error())
 		}
-		close(p.stopSignal)
+		close(registered)
 		err = lis.Close()
 		if err != nil {
 			log.Fatalf(err.Error())
 		}
 		log.Printf("[%s] Revoke service and close tcp socket", p.addr)
-	}()
+	}(p.registered)
+
+	// 监听其他节点的加入和离开
+	if !p.opts.StaticPeers {
+		ctx, cancel := context.WithCancel(context.Background())
+		p.stopWatch = cancel
+		p.watchDone = make(chan struct{})
+		go func(done chan struct{}) {
+			p.watchPeers(ctx)
+			close(done)
+		}(p.watchDone)
+	}
 
 	p.mu.Unlock()
 
@@ -126,6 +280,57 @@ func (p *Server) Start() error {
 	return nil
 }
 
+// Stop 优雅地停止服务 先从etcd注销 其他节点不再选中本节点 注销失败或者超过deregisterTimeout时仍然继续
+// 然后停止接收新的rpc 等待处理中的rpc结束 ctx结束时强制关闭所有连接并返回ctx.Err()
+func (p *Server) Stop(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.status {
+		p.mu.Unlock()
+		return fmt.Errorf("server not started")
+	}
+	p.status = false
+	grpcServer, registered, watchDone := p.grpcServer, p.registered, p.watchDone
+	if p.stopWatch != nil {
+		p.stopWatch()
+		p.stopWatch = nil
+		p.watchDone = nil
+	}
+	p.mu.Unlock()
+	// 等待监听退出 之后不会再创建新的Client和etcd client
+	if watchDone != nil {
+		<-watchDone
+	}
+
+	// 通知registry撤销租约 租约已经失效时registry已经退出
+	p.stopSignal <- nil
+	// etcd很慢或者不可用时不再等待注销 租约过期后服务同样会从etcd中删除
+	timer := time.NewTimer(deregisterTimeout)
+	select {
+	case <-registered:
+	case <-timer.C:
+		log.Printf("[%s] Deregistration did not finish in %v, the lease will expire", p.addr, deregisterTimeout)
+	case <-ctx.Done():
+	}
+	timer.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+		p.closeClients()
+		log.Printf("[%s] Server stopped", p.addr)
+		return nil
+	case <-ctx.Done():
+		grpcServer.Stop()
+		<-done
+		p.closeClients()
+		return ctx.Err()
+	}
+}
+
 func (p *Server) Log(format string, v ...interface{}) {
 	log.Printf("[Server %s] %s", p.addr, fmt.Sprintf(format, v...))
 }
@@ -150,7 +355,7 @@ func (p *Server) Serve(c *gin.Context) {
 		return
 	}
 
-	view, err := group.Get(key)
+	view, err := group.GetContext(c.Request.Context(), key)
 	if err != nil {
 		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -166,25 +371,196 @@ func (p *Server) Serve(c *gin.Context) {
 	c.Writer.Write(body)
 }
 
-// Set 将各个远端地址配置到Server里
+// Set 将各个远端地址配置到Server里 所有节点的权重都为1
 func (p *Server) Set(peers ...string) {
+	weights := make(map[string]int, len(peers))
+	for _, peer := range peers {
+		weights[peer] = 1
+	}
+	p.SetWeighted(weights)
+}
+
+// SetWeighted 将各个远端地址和它们的权重配置到Server里 只在哈希环上增删发生变化的节点 其余节点负责的key不变
+// 已有节点的Client和连接会被保留 被移除节点的Client会被关闭
+func (p *Server) SetWeighted(weights map[string]int) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	p.peers = consistenthash.New(defaultReplicas, nil)
-	p.peers.Add(peers...)
-	p.clients = make(map[string]*Client, len(peers))
-	for _, peer := range peers {
+	p.setWeighted(weights)
+}
+
+// setWeighted 调用时需要持有p.mu
+func (p *Server) setWeighted(weights map[string]int) {
+	if p.peers == nil {
+		p.peers = consistenthash.New(defaultReplicas, nil)
+		p.peers.SetLoad(p.opts.LoadEpsilon, p.peerLoad, p.totalLoad)
+	}
+	clients := make(map[string]*Client, len(weights))
+	for peer, weight := range weights {
 		if !CheckAddr(peer) {
 			panic(fmt.Sprintf("[peer %s] is invalid!", peer))
 		}
-		p.clients[peer] = NewClient(peer)
+		p.peers.AddWeighted(peer, weight)
+		if c, ok := p.clients[peer]; ok {
+			clients[peer] = c
+			continue
+		}
+		c := NewClientWithOptions(peer, p.opts.clientOptions())
+		c.etcd = p.etcdClient
+		c.total = &p.inflight
+		clients[peer] = c
+	}
+	for peer, c := range p.clients {
+		if _, ok := clients[peer]; !ok {
+			p.peers.Remove(peer)
+			c.Close()
+		}
+	}
+	p.clients = clients
+}
+
+// watchPeers 监听etcd中服务名下注册的所有节点 节点加入、离开或者租约失效时更新一致性哈希和clients
+// watch出错或者被etcd关闭时按指数退避重新监听 阻塞直到ctx结束
+func (p *Server) watchPeers(ctx context.Context) {
+	backoff := watchMinBackoff
+	for {
+		received, err := p.watchOnce(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			p.Log("failed to watch peers: %v", err)
+		} else {
+			p.Log("watch channel closed")
+		}
+		// 上一次监听正常工作过 从最小间隔开始重试
+		if received {
+			backoff = watchMinBackoff
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > watchMaxBackoff {
+			backoff = watchMaxBackoff
+		}
+	}
+}
+
+// watchOnce 监听一次 直到watch channel被关闭 received表示是否收到过更新
+func (p *Server) watchOnce(ctx context.Context) (received bool, err error) {
+	cli, err := p.etcdClient()
+	if err != nil {
+		return false, err
+	}
+	em, err := endpoints.NewManager(cli, p.opts.ServiceName)
+	if err != nil {
+		return false, err
+	}
+	ch, err := em.NewWatchChannel(ctx)
+	if err != nil {
+		return false, err
+	}
+	// 第一批更新包含所有已经注册的节点 重新监听时从头构建
+	members := make(map[string]endpoints.Endpoint)
+	for updates := range ch {
+		received = true
+		p.updatePeers(members, updates)
+	}
+	return received, nil
+}
+
+// updatePeers 把etcd中的变化应用到members(etcd key -> 节点) 并用最新的节点和权重重新配置Server
+// 服务已经停止时不再更新 避免创建没有人关闭的Client
+func (p *Server) updatePeers(members map[string]endpoints.Endpoint, updates []*endpoints.Update) {
+	for _, u := range updates {
+		switch u.Op {
+		case endpoints.Add:
+			members[u.Key] = u.Endpoint
+		case endpoints.Delete:
+			delete(members, u.Key)
+		}
+	}
+	weights := make(map[string]int, len(members))
+	for _, ep := range members {
+		if !CheckAddr(ep.Addr) {
+			p.Log("ignore invalid peer %s", ep.Addr)
+			continue
+		}
+		weights[ep.Addr] = endpointWeight(ep)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.status {
+		return
 	}
+	p.Log("peers changed: %v", weights)
+	p.setWeighted(weights)
+}
+
+// endpointWeight 读取节点注册时写入metadata的权重 没有设置时为1
+func endpointWeight(ep endpoints.Endpoint) int {
+	// metadata经过json编码 数字被解码为float64
+	if md, ok := ep.Metadata.(map[string]interface{}); ok {
+		if w, ok := md["weight"].(float64); ok && w >= 1 {
+			return int(w)
+		}
+	}
+	return 1
+}
+
+// etcdClient 返回所有Client共享的etcd client 第一次调用时创建
+func (p *Server) etcdClient() (*clientv3.Client, error) {
+	p.etcdMu.Lock()
+	defer p.etcdMu.Unlock()
+	if p.etcd == nil {
+		cli, err := clientv3.New(p.opts.Etcd.config())
+		if err != nil {
+			return nil, err
+		}
+		p.etcd = cli
+	}
+	return p.etcd, nil
+}
+
+// closeClients 关闭所有Client和共享的etcd client
+func (p *Server) closeClients() {
+	p.mu.Lock()
+	for _, c := range p.clients {
+		c.Close()
+	}
+	p.clients = nil
+	p.peers = nil
+	p.mu.Unlock()
+	p.etcdMu.Lock()
+	if p.etcd != nil {
+		p.etcd.Close()
+		p.etcd = nil
+	}
+	p.etcdMu.Unlock()
+}
+
+// peerLoad 发往节点的进行中rpc个数 本节点的负载计为0 调用时需要持有p.mu
+func (p *Server) peerLoad(peer string) int64 {
+	if c, ok := p.clients[peer]; ok && peer != p.addr {
+		return atomic.LoadInt64(&c.inflight)
+	}
+	return 0
+}
+
+// totalLoad 发往所有远端节点的进行中rpc个数 包括已经被移除但rpc还没有结束的节点
+func (p *Server) totalLoad() int64 {
+	return atomic.LoadInt64(&p.inflight)
 }
 
 // PickPeer 根据一致性哈希找到key应该存放的节点 返回false说明应该从本地获取
+// 启用ServerOptions.LoadEpsilon时 进行中请求过多的节点会被跳过
 func (p *Server) PickPeer(key string) (PeerGetter, bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil, false
+	}
 	if peer := p.peers.GetPeer(key); peer != "" && peer != p.addr {
 		p.Log("server:  Pick remote peer %s", peer)
 		return p.clients[peer], true
@@ -192,4 +568,19 @@ func (p *Server) PickPeer(key string) (PeerGetter, bool) {
 	return nil, false
 }
 
+// PickOwner 根据一致性哈希找到key所在的节点 不考虑节点的负载 返回false说明key由本节点负责
+func (p *Server) PickOwner(key string) (PeerGetter, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.peers == nil {
+		return nil, false
+	}
+	if peer := p.peers.Owner(key); peer != "" && peer != p.addr {
+		return p.clients[peer], true
+	}
+	return nil, false
+}
+
 var _ PeerPicker = (*Server)(nil)
+
+var _ pb.GroupCacheServer = rpcServer{}
@@ -0,0 +1,429 @@
+package hyliocache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/hylio/hyliocache/consistenthash"
+	pb "github.com/hylio/hyliocache/hyliocachepb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/naming/endpoints"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStopNotStarted(t *testing.T) {
+	p := NewServer("")
+	if err := p.Stop(context.Background()); err == nil {
+		t.Fatal("stopping a server that is not started should fail")
+	}
+}
+
+func TestStartInvalidAddr(t *testing.T) {
+	// 和Set一样 带scheme的地址不是合法的节点地址
+	p := NewServer("http://localhost:8002")
+	if err := p.Start(); err == nil {
+		t.Fatal("starting a server with a scheme-prefixed addr should fail")
+	}
+	if err := p.Stop(context.Background()); err == nil {
+		t.Fatal("server with an invalid addr should not be started")
+	}
+}
+
+func TestSetReusesClients(t *testing.T) {
+	p := NewServer("127.0.0.1:8000")
+	p.Set("127.0.0.1:8001", "127.0.0.1:8002")
+	c1, c2 := p.clients["127.0.0.1:8001"], p.clients["127.0.0.1:8002"]
+
+	p.Set("127.0.0.1:8001", "127.0.0.1:8003")
+	if p.clients["127.0.0.1:8001"] != c1 {
+		t.Fatal("client of an existing peer should be reused")
+	}
+	if !c2.closed {
+		t.Fatal("client of a removed peer should be closed")
+	}
+	if got := p.peers.Members(); !reflect.DeepEqual(got, []string{"127.0.0.1:8001", "127.0.0.1:8003"}) {
+		t.Fatalf("ring should only contain current peers, got %v", got)
+	}
+	if _, err := c2.getConn(); err != errClientClosed {
+		t.Fatalf("closed client should not reconnect, got %v", err)
+	}
+}
+
+func TestUpdatePeers(t *testing.T) {
+	p := NewServer("127.0.0.1:8000")
+	p.status = true
+	members := make(map[string]endpoints.Endpoint)
+	peers := func() []string {
+		var addrs []string
+		for addr := range p.clients {
+			addrs = append(addrs, addr)
+		}
+		sort.Strings(addrs)
+		return addrs
+	}
+
+	p.updatePeers(members, []*endpoints.Update{
+		{Op: endpoints.Add, Key: "_hyliocache/127.0.0.1:8000", Endpoint: endpoints.Endpoint{Addr: "127.0.0.1:8000"}},
+		{Op: endpoints.Add, Key: "_hyliocache/127.0.0.1:8001", Endpoint: endpoints.Endpoint{Addr: "127.0.0.1:8001"}},
+		{Op: endpoints.Add, Key: "_hyliocache/invalid", Endpoint: endpoints.Endpoint{Addr: "invalid"}},
+	})
+	if got := peers(); !reflect.DeepEqual(got, []string{"127.0.0.1:8000", "127.0.0.1:8001"}) {
+		t.Fatalf("registered peers should be added, got %v", got)
+	}
+
+	// 节点离开或者租约失效
+	p.updatePeers(members, []*endpoints.Update{
+		{Op: endpoints.Delete, Key: "_hyliocache/127.0.0.1:8001"},
+		{Op: endpoints.Add, Key: "_hyliocache/127.0.0.1:8002", Endpoint: endpoints.Endpoint{Addr: "127.0.0.1:8002"}},
+	})
+	if got := peers(); !reflect.DeepEqual(got, []string{"127.0.0.1:8000", "127.0.0.1:8002"}) {
+		t.Fatalf("peers should follow etcd, got %v", got)
+	}
+	for i := 0; i < 100; i++ {
+		if peer, ok := p.PickPeer(strconv.Itoa(i)); ok && peer != p.clients["127.0.0.1:8002"] {
+			t.Fatal("removed peer should not be picked")
+		}
+	}
+
+	// 服务停止后 还在处理中的更新不会重新创建Client
+	p.status = false
+	p.closeClients()
+	p.updatePeers(members, []*endpoints.Update{
+		{Op: endpoints.Add, Key: "_hyliocache/127.0.0.1:8003", Endpoint: endpoints.Endpoint{Addr: "127.0.0.1:8003"}},
+	})
+	if p.clients != nil || p.peers != nil {
+		t.Fatal("updates after stop should be ignored")
+	}
+}
+
+func TestEndpointWeight(t *testing.T) {
+	testcases := []struct {
+		metadata interface{}
+		weight   int
+	}{
+		{nil, 1},
+		{map[string]interface{}{"weight": float64(4)}, 4},
+		{map[string]interface{}{"weight": float64(0)}, 1},
+		{"weight", 1},
+	}
+	for _, tc := range testcases {
+		if w := endpointWeight(endpoints.Endpoint{Metadata: tc.metadata}); w != tc.weight {
+			t.Errorf("metadata %v, want weight %d, but got %d", tc.metadata, tc.weight, w)
+		}
+	}
+}
+
+func TestPickPeerBoundedLoad(t *testing.T) {
+	p := NewServerWithOptions("127.0.0.1:8000", ServerOptions{LoadEpsilon: 0.25})
+	p.Set("127.0.0.1:8000", "127.0.0.1:8001", "127.0.0.1:8002")
+
+	// 找到一个由8001负责的key
+	busy := p.clients["127.0.0.1:8001"]
+	key := ""
+	for i := 0; key == ""; i++ {
+		if peer, ok := p.PickPeer(strconv.Itoa(i)); ok && peer == busy {
+			key = strconv.Itoa(i)
+		}
+	}
+
+	// 8001上有大量进行中的请求 key被分给其他节点
+	busy.add(10)
+	if peer, ok := p.PickPeer(key); ok && peer == busy {
+		t.Fatal("overloaded peer should be skipped")
+	}
+}
+
+// fakeRegistry 代替etcd注册 记录注销的顺序
+type fakeRegistry struct {
+	revokeErr error
+	events    chan string
+	// hang 不为nil时 撤销租约一直阻塞到hang被关闭 模拟etcd不可用
+	hang chan struct{}
+}
+
+func (r *fakeRegistry) register(cfg clientv3.Config, ttl int64, service, addr string, metadata interface{}, stop chan error) error {
+	<-stop
+	r.events <- "deregister"
+	if r.hang != nil {
+		<-r.hang
+	}
+	return r.revokeErr
+}
+
+// startTestServer 在空闲端口上启动Server 返回连接到它的grpc客户端
+func startTestServer(t *testing.T, r *fakeRegistry) (*Server, pb.GroupCacheClient, chan error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+
+	p := NewServerWithOptions(addr, ServerOptions{StaticPeers: true})
+	p.register = r.register
+	served := make(chan error, 1)
+	go func() {
+		served <- p.Start()
+	}()
+	// 等待开始监听 grpc重连的间隔太长
+	deadline := time.Now().Add(time.Second)
+	for {
+		c, err := net.Dial("tcp", addr)
+		if err == nil {
+			c.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return p, pb.NewGroupCacheClient(conn), served
+}
+
+func TestStopDrainsAfterDeregister(t *testing.T) {
+	release := make(chan struct{})
+	loading := make(chan struct{})
+	NewGroup("stop_drain_name", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		close(loading)
+		<-release
+		return []byte("hylio"), nil
+	}))
+	r := &fakeRegistry{events: make(chan string, 1)}
+	p, client, served := startTestServer(t, r)
+
+	got := make(chan error, 1)
+	go func() {
+		resp, err := client.Get(context.Background(), &pb.Request{Group: "stop_drain_name", Key: "zhanghao"})
+		if err == nil && string(resp.GetValue()) != "hylio" {
+			err = fmt.Errorf("unexpected value %s", resp.GetValue())
+		}
+		got <- err
+	}()
+	<-loading
+
+	// 撤销租约失败也不应该影响停止
+	r.revokeErr = errors.New("revoke failed")
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- p.Stop(context.Background())
+	}()
+	// 先从etcd注销 此时处理中的rpc还没有结束
+	if e := <-r.events; e != "deregister" {
+		t.Fatalf("unexpected event %s", e)
+	}
+	select {
+	case err := <-stopped:
+		t.Fatalf("stop should wait for in-flight rpc, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if err := <-got; err != nil {
+		t.Fatalf("in-flight rpc should complete, got %v", err)
+	}
+	if err := <-stopped; err != nil {
+		t.Fatalf("graceful stop should succeed, got %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("Start should return after stop, got %v", err)
+	}
+}
+
+func TestStopWhenDeregisterHangs(t *testing.T) {
+	defer func(d time.Duration) { deregisterTimeout = d }(deregisterTimeout)
+	deregisterTimeout = 50 * time.Millisecond
+	r := &fakeRegistry{events: make(chan string, 1), hang: make(chan struct{})}
+	defer close(r.hang)
+	p, _, served := startTestServer(t, r)
+
+	// etcd没有响应 Stop仍然继续优雅停止
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- p.Stop(context.Background())
+	}()
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Fatalf("stop should succeed, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("stop should not wait for deregistration forever")
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("Start should return after stop, got %v", err)
+	}
+}
+
+func TestStopForcedOnTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	loading := make(chan struct{})
+	NewGroup("stop_timeout_name", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		close(loading)
+		<-release
+		return []byte("hylio"), nil
+	}))
+	r := &fakeRegistry{events: make(chan string, 1)}
+	p, client, _ := startTestServer(t, r)
+
+	got := make(chan error, 1)
+	go func() {
+		_, err := client.Get(context.Background(), &pb.Request{Group: "stop_timeout_name", Key: "zhanghao"})
+		got <- err
+	}()
+	<-loading
+
+	// rpc没有在ctx结束前完成 强制关闭连接
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := p.Stop(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expect %v, but got %v", context.DeadlineExceeded, err)
+	}
+	if err := <-got; err == nil {
+		t.Fatal("in-flight rpc should fail after forced stop")
+	}
+}
+
+func TestSpillServedLocally(t *testing.T) {
+	var loads int32
+	g := NewGroup("spill_name", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		return []byte("spill:" + key), nil
+	}))
+	// c是实际启动的节点 a发起请求 b是key所在的节点
+	c, _, _ := startTestServer(t, &fakeRegistry{events: make(chan string, 1)})
+	defer c.Stop(context.Background())
+	a, b := "127.0.0.1:8000", "127.0.0.1:8001"
+	p := NewServerWithOptions(a, ServerOptions{StaticPeers: true, LoadEpsilon: 0.25})
+	p.Set(a, b, c.addr)
+	g.RegisterPeers(p)
+	conn, err := grpc.Dial(c.addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	p.clients[c.addr].conn = conn
+
+	// b上有大量进行中的请求 找到由b负责但被分给c的key
+	p.clients[b].add(10)
+	ring := consistenthash.New(defaultReplicas, nil)
+	ring.Add(a, b, c.addr)
+	var keys []string
+	for i := 0; len(keys) < 2; i++ {
+		key := strconv.Itoa(i)
+		if peer, ok := p.PickPeer(key); ok && ring.GetPeer(key) == b && peer == p.clients[c.addr] {
+			keys = append(keys, key)
+		}
+	}
+
+	// c直接从数据源加载 不会再按哈希环转发给b或者自己
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if view, err := g.GetContext(ctx, keys[0]); err != nil || view.String() != "spill:"+keys[0] {
+		t.Fatalf("spilled key should be served by c, got %s %v", view, err)
+	}
+	if values, errs := g.GetManyContext(ctx, keys[1:]); errs != nil || values[keys[1]].String() != "spill:"+keys[1] {
+		t.Fatalf("spilled batch should be served by c, got %v %v", values, errs)
+	}
+	if n := atomic.LoadInt32(&loads); n != 2 {
+		t.Fatalf("each key should be loaded once, loads = %d", n)
+	}
+	if p.clients[b].conn != nil {
+		t.Fatal("overloaded owner should not be contacted")
+	}
+	// c不负责这些key Remove不会通知到c 不能放进mainCache
+	if n := g.CacheStats().Items; n != 0 {
+		t.Fatalf("spilled values should not be kept in main cache, got %d", n)
+	}
+}
+
+// clientPicker 用Server选择节点 再把选中的Client换成记录请求的fakePeer
+type clientPicker struct {
+	*Server
+	peers map[string]*fakePeer
+}
+
+func (p clientPicker) PickPeer(key string) (PeerGetter, bool) {
+	if c, ok := p.Server.PickPeer(key); ok {
+		return p.peers[c.(*Client).addr], true
+	}
+	return nil, false
+}
+
+func (p clientPicker) PickOwner(key string) (PeerGetter, bool) {
+	if c, ok := p.Server.PickOwner(key); ok {
+		return p.peers[c.(*Client).addr], true
+	}
+	return nil, false
+}
+
+func TestRemoveOwnerOverBound(t *testing.T) {
+	a, b, c := "127.0.0.1:8000", "127.0.0.1:8001", "127.0.0.1:8002"
+	p := NewServerWithOptions(a, ServerOptions{StaticPeers: true, LoadEpsilon: 0.25})
+	p.Set(a, b, c)
+	owner, spill := &fakePeer{}, &fakePeer{}
+	g := NewGroup("remove_bound_name", 2<<10, GetterFunc(func(key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}))
+	g.RegisterPeers(clientPicker{Server: p, peers: map[string]*fakePeer{b: owner, c: spill}})
+
+	// b的负载超过上限 找到由b负责但读请求被分给c的key
+	p.clients[b].add(10)
+	key := ""
+	for i := 0; key == ""; i++ {
+		k := strconv.Itoa(i)
+		if peer, ok := p.PickPeer(k); ok && peer == p.clients[c] && p.peers.Owner(k) == b {
+			key = k
+		}
+	}
+	if _, err := g.Get(key); err != nil || spill.gets != 1 || owner.gets != 0 {
+		t.Fatalf("read should go to the less loaded peer, err = %v", err)
+	}
+
+	// 写入和删除仍然发给key所在的节点
+	if err := g.Remove(key); err != nil || !reflect.DeepEqual(owner.deleted, []string{key}) || len(spill.deleted) != 0 {
+		t.Fatalf("remove should reach the owner, owner %v, spill %v", owner.deleted, spill.deleted)
+	}
+	if err := g.Set(key, []byte("v")); err != nil || owner.set[key] != "v" || spill.set != nil {
+		t.Fatal("set should reach the owner")
+	}
+}
+
+func TestClientKeepsConnOnUnavailable(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := lis.Addr().String()
+	lis.Close()
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewClient(addr)
+	defer c.Close()
+	c.conn = conn
+
+	// 远端节点不可用时rpc失败 但共享的连接不会被关闭 其他rpc不受影响
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := c.Delete(ctx, &pb.Request{Group: "conn_name", Key: "zhanghao"}); err == nil {
+		t.Fatal("rpc to an unavailable peer should fail")
+	}
+	if c.conn != conn || conn.GetState() == connectivity.Shutdown {
+		t.Fatal("connection should be kept after an unavailable error")
+	}
+}
@@ -1,7 +1,9 @@
 package singleflight
 
 import (
+	"context"
 	"sync"
+	"time"
 )
 
 // singleflight模块提供防止缓存击穿的能力
@@ -11,9 +13,11 @@ import (
 
 // call 表示正在进行中的请求
 type call struct {
-	wg  sync.WaitGroup
-	val interface{}
-	err error
+	done chan struct{} // 请求完成后关闭
+	val  interface{}
+	err  error
+	refs int      // 仍在等待结果的调用方个数
+	ctx  *callCtx // fn的ctx
 }
 
 // Group 管理不同key的请求
@@ -23,33 +27,118 @@ type Group struct {
 }
 
 func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	return g.DoContext(context.Background(), key, func(context.Context) (interface{}, error) {
+		return fn()
+	})
+}
+
+// DoContext 与Do相同 但每个调用方只等待到自己的ctx结束 返回ctx.Err()
+// fn在独立的ctx中执行 所有调用方都放弃等待后fn的ctx才会被取消
+// fn的ctx的截止时间是所有调用方中最晚的截止时间 有调用方没有截止时间时fn的ctx也没有截止时间
+func (g *Group) DoContext(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
 	g.mu.Lock()
 	if g.m == nil {
 		g.m = make(map[string]*call)
 	}
 	// 对于一个key而言 Group中已经有了相同的请求
 	// 说明此时应该等待已经发起的请求做完
-	// 因此进入wait状态
-	// 此时可以将锁释放 这样其他相同的请求也能直接进入到等待状态
-	if c, ok := g.m[key]; ok {
-		g.mu.Unlock()
-		c.wg.Wait()
-		return c.val, c.err
+	// 否则当前请求是第一个获取到资源的请求 由它发起请求
+	c, ok := g.m[key]
+	if !ok {
+		c = &call{done: make(chan struct{}), ctx: newCallCtx()}
+		g.m[key] = c
+		go g.run(key, c, fn)
 	}
-	// 如果Group中没有相同的请求
-	// 说明当前请求是第一个获取到资源的请求
-	// 那么它应该继续完成后续的动作
-	c := new(call)
-	c.wg.Add(1)
-	g.m[key] = c
+	w := c.ctx.join(ctx)
+	c.refs++
 	g.mu.Unlock()
 
-	c.val, c.err = fn()
-	c.wg.Done()
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		c.ctx.leave(w)
+		g.mu.Lock()
+		c.refs--
+		if c.refs == 0 {
+			// 没有调用方在等待 取消fn 之后相同的请求会重新发起
+			c.ctx.cancel()
+			if g.m[key] == c {
+				delete(g.m, key)
+			}
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+func (g *Group) run(key string, c *call, fn func(ctx context.Context) (interface{}, error)) {
+	defer c.ctx.cancel()
+	c.val, c.err = fn(c.ctx)
 
 	g.mu.Lock()
-	delete(g.m, key)
+	if g.m[key] == c {
+		delete(g.m, key)
+	}
 	g.mu.Unlock()
+	close(c.done)
+}
+
+// callCtx fn使用的ctx 截止时间是当前所有等待的调用方中最晚的截止时间
+// 截止时间会通过Deadline传给grpc和数据源 之后加入的调用方不会因为第一个调用方的截止时间而失败
+// 不需要定时器 所有调用方都因为超时放弃等待后ctx会被取消
+type callCtx struct {
+	context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	waiters map[*waiter]struct{}
+}
+
+type waiter struct {
+	ctx context.Context
+}
+
+func newCallCtx() *callCtx {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &callCtx{Context: ctx, cancel: cancel, waiters: make(map[*waiter]struct{})}
+}
+
+func (c *callCtx) join(ctx context.Context) *waiter {
+	w := &waiter{ctx: ctx}
+	c.mu.Lock()
+	c.waiters[w] = struct{}{}
+	c.mu.Unlock()
+	return w
+}
 
-	return c.val, c.err
+func (c *callCtx) leave(w *waiter) {
+	c.mu.Lock()
+	delete(c.waiters, w)
+	c.mu.Unlock()
+}
+
+// Deadline 有调用方没有截止时间时 fn的ctx也没有截止时间
+func (c *callCtx) Deadline() (time.Time, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var latest time.Time
+	for w := range c.waiters {
+		d, ok := w.ctx.Deadline()
+		if !ok {
+			return time.Time{}, false
+		}
+		if d.After(latest) {
+			latest = d
+		}
+	}
+	return latest, !latest.IsZero()
+}
+
+// Loading 返回key是否有进行中的请求
+func (g *Group) Loading(key string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, ok := g.m[key]
+	return ok
 }
@@ -0,0 +1,67 @@
+package hyliocache
+
+import (
+	pb "github.com/hylio/hyliocache/hyliocachepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"io"
+)
+
+/*
+GetStream rpc 把value拆成多个Chunk发送
+单个grpc消息不能超过MaxMsgSize 大value通过流式传输不受这个限制
+*/
+
+// streamChunkSize 每个Chunk的最大字节数
+const streamChunkSize = 64 << 10
+
+// sendChunks 把data按streamChunkSize分片发送 每个Chunk直接引用data 不复制数据
+func sendChunks(data []byte, send func(*pb.Chunk) error) error {
+	for len(data) > 0 {
+		n := streamChunkSize
+		if len(data) < n {
+			n = len(data)
+		}
+		if err := send(&pb.Chunk{Data: data[:n]}); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// streamReader 把GetStream返回的Chunk拼接成io.Reader
+type streamReader struct {
+	recv  func() (*pb.Chunk, error)
+	buf   []byte
+	close func()
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		chunk, err := r.recv()
+		if err == io.EOF {
+			return 0, io.EOF
+		}
+		if err != nil {
+			// 流式rpc的错误在第一次Recv时才会返回
+			if status.Code(err) == codes.NotFound {
+				return 0, ErrNotFound
+			}
+			return 0, err
+		}
+		r.buf = chunk.GetData()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Close 取消rpc并释放连接
+func (r *streamReader) Close() error {
+	if r.close != nil {
+		r.close()
+		r.close = nil
+	}
+	return nil
+}
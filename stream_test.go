@@ -0,0 +1,67 @@
+package hyliocache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	pb "github.com/hylio/hyliocache/hyliocachepb"
+	"google.golang.org/grpc"
+	"io"
+	"testing"
+)
+
+// fakeStream 模拟GetStream的服务端流 记录发送的Chunk
+type fakeStream struct {
+	grpc.ServerStream
+	chunks []*pb.Chunk
+}
+
+func (s *fakeStream) Context() context.Context {
+	return context.Background()
+}
+
+func (s *fakeStream) Send(c *pb.Chunk) error {
+	// 发送时已经完成序列化 这里需要复制一份
+	s.chunks = append(s.chunks, &pb.Chunk{Data: cloneBytes(c.GetData())})
+	return nil
+}
+
+// reader 模拟客户端依次接收Chunk
+func (s *fakeStream) reader() io.Reader {
+	i := 0
+	return &streamReader{recv: func() (*pb.Chunk, error) {
+		if i == len(s.chunks) {
+			return nil, io.EOF
+		}
+		i++
+		return s.chunks[i-1], nil
+	}}
+}
+
+func TestGetStream(t *testing.T) {
+	large := bytes.Repeat([]byte("hylio"), streamChunkSize) // 5个Chunk
+	NewGroup("stream_name", 0, GetterFunc(func(key string) ([]byte, error) {
+		if key == "large" {
+			return large, nil
+		}
+		return nil, ErrNotFound
+	}))
+	p := NewServer("")
+
+	stream := &fakeStream{}
+	if err := p.GetStream(&pb.Request{Group: "stream_name", Key: "large"}, stream); err != nil {
+		t.Fatal(err)
+	}
+	if len(stream.chunks) != 5 {
+		t.Fatalf("value should be split into 5 chunks, got %d", len(stream.chunks))
+	}
+	if got, err := io.ReadAll(stream.reader()); err != nil || !bytes.Equal(got, large) {
+		t.Fatal("chunks should be assembled into the original value")
+	}
+
+	err := p.GetStream(&pb.Request{Group: "stream_name", Key: "unknown"}, &fakeStream{})
+	r := &streamReader{recv: func() (*pb.Chunk, error) { return nil, err }}
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expect ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package tinylfu
+
+import "hash/fnv"
+
+/*
+	count-min sketch 用很小的内存近似统计每个key的访问频次
+	每行使用不同的哈希位置 估计值取所有行中的最小值
+	计数器上限为15(相当于4bit计数器) 累计次数达到resetAt后所有计数减半
+	这样过去的热点会逐渐冷却 新的热点有机会进入缓存
+*/
+
+const (
+	cmDepth    = 4  // 行数
+	cmMaxCount = 15 // 单个计数器的上限
+)
+
+type cmSketch struct {
+	rows      [cmDepth][]uint8
+	mask      uint64
+	additions int // 上次重置以来的计数次数
+	resetAt   int // 计数次数达到resetAt时重置
+}
+
+// newCMSketch width会向上取整为2的幂
+func newCMSketch(width int) *cmSketch {
+	w := 1
+	for w < width {
+		w <<= 1
+	}
+	s := &cmSketch{
+		mask:    uint64(w - 1),
+		resetAt: 10 * w,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, w)
+	}
+	return s
+}
+
+func (s *cmSketch) hash(key string) (uint64, uint64) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	// 由一个64位哈希派生出每一行的位置
+	return sum & 0xffffffff, sum>>32 | 1
+}
+
+// Increment 记录一次访问
+func (s *cmSketch) Increment(key string) {
+	h1, h2 := s.hash(key)
+	for i := range s.rows {
+		idx := (h1 + uint64(i)*h2) & s.mask
+		if s.rows[i][idx] < cmMaxCount {
+			s.rows[i][idx]++
+		}
+	}
+	s.additions++
+	if s.additions >= s.resetAt {
+		s.reset()
+	}
+}
+
+// Estimate 估计key的访问频次
+func (s *cmSketch) Estimate(key string) uint8 {
+	h1, h2 := s.hash(key)
+	min := uint8(cmMaxCount)
+	for i := range s.rows {
+		if v := s.rows[i][(h1+uint64(i)*h2)&s.mask]; v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+// reset 所有计数减半
+func (s *cmSketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions = 0
+}
@@ -0,0 +1,250 @@
+package tinylfu
+
+import (
+	"container/list"
+	"github.com/hylio/hyliocache/lru"
+	"time"
+)
+
+/*
+	W-TinyLFU
+	新元素先进入很小的窗口LRU(1%的内存) 窗口淘汰出的元素作为候选者
+	候选者需要和主区的淘汰者比较访问频次 频次更高才能进入主区 否则直接丢弃
+	主区是分段LRU: 试用区(20%)保存只访问过一次的元素 再次命中后晋升到保护区(80%)
+	访问频次由count-min sketch统计 这样只访问一次的key无法挤掉真正的热点
+*/
+
+const (
+	windowRatio    = 0.01 // 窗口占总内存的比例
+	protectedRatio = 0.8  // 保护区占主区的比例
+
+	// sketch的宽度按平均每个元素sketchBytesPerEntry字节估算
+	sketchBytesPerEntry = 32
+	minSketchWidth      = 1 << 6
+	maxSketchWidth      = 1 << 20
+	defaultSketchWidth  = 1 << 16
+)
+
+type segment int
+
+const (
+	window segment = iota
+	probation
+	protected
+)
+
+type Cache struct {
+	maxBytes     int64
+	windowMax    int64 // 窗口的内存限制
+	protectedMax int64 // 保护区的内存限制
+	mainMax      int64 // 主区(试用区+保护区)的内存限制
+	bytes        [3]int64
+	lists        [3]*list.List // 队尾为最近访问
+	cache        map[string]*list.Element
+	sketch       *cmSketch
+	OnEvicted    func(key string, value lru.Value) // 回调函数 在淘汰数据时执行其他操作
+}
+
+type entry struct {
+	key    string
+	value  lru.Value
+	expire time.Time // 过期时间 零值表示永不过期
+	seg    segment
+}
+
+func (e *entry) size() int64 {
+	return int64(e.value.Len()) + int64(len(e.key))
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expire.IsZero() && now.After(e.expire)
+}
+
+// New maxBytes为0时不限制内存 此时不会发生淘汰
+func New(maxBytes int64, onEvicted func(string, lru.Value)) *Cache {
+	windowMax := int64(float64(maxBytes) * windowRatio)
+	if maxBytes > 0 && windowMax == 0 {
+		windowMax = 1
+	}
+	mainMax := maxBytes - windowMax
+	width := defaultSketchWidth
+	if maxBytes > 0 {
+		width = int(maxBytes / sketchBytesPerEntry)
+		if width < minSketchWidth {
+			width = minSketchWidth
+		}
+		if width > maxSketchWidth {
+			width = maxSketchWidth
+		}
+	}
+	return &Cache{
+		maxBytes:     maxBytes,
+		windowMax:    windowMax,
+		protectedMax: int64(float64(mainMax) * protectedRatio),
+		mainMax:      mainMax,
+		lists:        [3]*list.List{list.New(), list.New(), list.New()},
+		cache:        make(map[string]*list.Element),
+		sketch:       newCMSketch(width),
+		OnEvicted:    onEvicted,
+	}
+}
+
+// NewPolicy 可以作为lru.NewPolicy传给hyliocache.WithPolicy
+func NewPolicy(maxBytes int64, onEvicted func(key string, value lru.Value)) lru.Policy {
+	return New(maxBytes, onEvicted)
+}
+
+// Get 查找元素 已过期的元素视为未命中
+func (c *Cache) Get(key string) (value lru.Value, ok bool) {
+	c.sketch.Increment(key)
+	ele, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	kv := ele.Value.(*entry)
+	if kv.expired(time.Now()) {
+		c.removeElement(ele)
+		return nil, false
+	}
+	c.touch(ele)
+	c.evict(nil)
+	return kv.value, true
+}
+
+// Remove 淘汰一个元素 优先淘汰试用区 其次是保护区和窗口
+func (c *Cache) Remove() {
+	c.removeOldest(nil)
+}
+
+// removeOldest 淘汰一个元素 被淘汰的key追加到evicted中返回
+func (c *Cache) removeOldest(evicted []string) []string {
+	for _, seg := range []segment{probation, protected, window} {
+		if ele := c.lists[seg].Front(); ele != nil {
+			evicted = append(evicted, ele.Value.(*entry).key)
+			c.removeElement(ele)
+			return evicted
+		}
+	}
+	return evicted
+}
+
+// Delete 删除指定的元素 返回元素是否存在
+func (c *Cache) Delete(key string) bool {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele)
+		return true
+	}
+	return false
+}
+
+// Add 添加或修改元素 元素永不过期
+func (c *Cache) Add(key string, value lru.Value) (evicted []string, err error) {
+	return c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithExpire 添加或修改元素 新元素总是先进入窗口 expire为零值表示永不过期
+// 返回本次被淘汰的key 包括没有通过准入的新元素 元素本身超过maxBytes时返回lru.ErrTooLarge
+func (c *Cache) AddWithExpire(key string, value lru.Value, expire time.Time) (evicted []string, err error) {
+	c.sketch.Increment(key)
+	ele, ok := c.cache[key]
+	if c.maxBytes != 0 && int64(value.Len())+int64(len(key)) > c.maxBytes {
+		if ok {
+			evicted = append(evicted, key)
+			c.removeElement(ele)
+		}
+		return evicted, lru.ErrTooLarge
+	}
+	if ok {
+		kv := ele.Value.(*entry)
+		c.bytes[kv.seg] += int64(value.Len()) - int64(kv.value.Len())
+		kv.value = value
+		kv.expire = expire
+		c.touch(ele)
+	} else {
+		kv := &entry{key: key, value: value, expire: expire, seg: window}
+		c.bytes[window] += kv.size()
+		c.cache[key] = c.lists[window].PushBack(kv)
+	}
+	return c.evict(evicted), nil
+}
+
+func (c *Cache) Len() int {
+	return len(c.cache)
+}
+
+func (c *Cache) Bytes() int64 {
+	return c.bytes[window] + c.bytes[probation] + c.bytes[protected]
+}
+
+// touch 记录一次命中 试用区的元素晋升到保护区
+func (c *Cache) touch(ele *list.Element) {
+	kv := ele.Value.(*entry)
+	if kv.seg == probation {
+		c.move(ele, protected)
+		return
+	}
+	c.lists[kv.seg].MoveToBack(ele)
+}
+
+// move 把元素移动到seg段的队尾
+func (c *Cache) move(ele *list.Element, seg segment) {
+	kv := ele.Value.(*entry)
+	c.lists[kv.seg].Remove(ele)
+	c.bytes[kv.seg] -= kv.size()
+	kv.seg = seg
+	c.bytes[seg] += kv.size()
+	c.cache[kv.key] = c.lists[seg].PushBack(kv)
+}
+
+// evict 让每一段都回到内存限制以内 被淘汰的key追加到evicted中返回
+func (c *Cache) evict(evicted []string) []string {
+	if c.maxBytes == 0 {
+		return evicted
+	}
+	// 保护区超出 最久未访问的元素降级到试用区
+	for c.bytes[protected] > c.protectedMax && c.lists[protected].Len() > 0 {
+		c.move(c.lists[protected].Front(), probation)
+	}
+	// 窗口超出 淘汰出的元素作为候选者尝试进入主区
+	for c.bytes[window] > c.windowMax && c.lists[window].Len() > 0 {
+		evicted = c.admit(c.lists[window].Front(), evicted)
+	}
+	for c.bytes[probation]+c.bytes[protected] > c.mainMax {
+		evicted = c.removeOldest(evicted)
+	}
+	return evicted
+}
+
+// admit 准入策略 候选者的访问频次高于主区的淘汰者时才能进入主区
+func (c *Cache) admit(candidate *list.Element, evicted []string) []string {
+	kv := candidate.Value.(*entry)
+	if c.bytes[probation]+c.bytes[protected]+kv.size() > c.mainMax {
+		victim := c.lists[probation].Front()
+		if victim == nil {
+			victim = c.lists[protected].Front()
+		}
+		if victim != nil && c.sketch.Estimate(kv.key) <= c.sketch.Estimate(victim.Value.(*entry).key) {
+			c.removeElement(candidate)
+			return append(evicted, kv.key)
+		}
+	}
+	// 先腾出空间再进入主区
+	for c.bytes[probation]+c.bytes[protected]+kv.size() > c.mainMax && c.lists[probation].Len()+c.lists[protected].Len() > 0 {
+		evicted = c.removeOldest(evicted)
+	}
+	c.move(candidate, probation)
+	return evicted
+}
+
+func (c *Cache) removeElement(ele *list.Element) {
+	kv := ele.Value.(*entry)
+	c.lists[kv.seg].Remove(ele)
+	c.bytes[kv.seg] -= kv.size()
+	delete(c.cache, kv.key)
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value)
+	}
+}
+
+// 测试Cache是否实现了lru.Policy接口
+var _ lru.Policy = (*Cache)(nil)
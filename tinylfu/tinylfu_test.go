@@ -0,0 +1,171 @@
+package tinylfu
+
+import (
+	"fmt"
+	"github.com/hylio/hyliocache/lru"
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type Str string
+
+func (s Str) Len() int {
+	return len(s)
+}
+
+func TestCache_Get(t *testing.T) {
+	c := New(0, nil)
+	c.Add("name", Str("hylio"))
+	if v, ok := c.Get("name"); !ok || string(v.(Str)) != "hylio" {
+		t.Error("hit fail")
+	}
+	if _, ok := c.Get("age"); ok {
+		t.Error("miss fail")
+	}
+}
+
+func TestCache_Add(t *testing.T) {
+	k1 := "name"
+	v1 := "hylio"
+	v2 := "zh"
+	c := New(1024, nil)
+	c.Add(k1, Str(v1))
+	if c.Bytes() != int64(len(k1+v1)) {
+		t.Error("add k1 fail")
+	}
+	c.Add(k1, Str(v2))
+	if v, ok := c.Get(k1); !ok || string(v.(Str)) != v2 || c.Bytes() != int64(len(k1+v2)) {
+		t.Error("update k1 fail")
+	}
+}
+
+func TestAdmission(t *testing.T) {
+	del := make([]string, 0)
+	callback := func(key string, value lru.Value) {
+		del = append(del, key)
+	}
+	c := New(100, callback)
+	// 填满主区 并让热点多次被访问
+	for i := 0; i < 9; i++ {
+		c.Add(fmt.Sprintf("hot%d", i), Str("0123456"))
+	}
+	for n := 0; n < 5; n++ {
+		for i := 0; i < 9; i++ {
+			c.Get(fmt.Sprintf("hot%d", i))
+		}
+	}
+	del = del[:0]
+	// 只访问一次的key不应该挤掉热点
+	c.Add("scan1", Str("0123456"))
+	c.Add("scan2", Str("0123456"))
+	for i := 0; i < 9; i++ {
+		if _, ok := c.Get(fmt.Sprintf("hot%d", i)); !ok {
+			t.Fatalf("hot%d should not be evicted", i)
+		}
+	}
+	if !reflect.DeepEqual(del, []string{"scan1", "scan2"}) {
+		t.Fatalf("expect scan keys to be rejected, but evicted %v", del)
+	}
+}
+
+func TestLimit(t *testing.T) {
+	c := New(10, nil)
+	c.Add("name", Str("hylio"))
+	c.Add("sex", Str("malemalemalemale"))
+	if _, ok := c.Get("sex"); ok || c.Bytes() > 10 {
+		t.Error("limit fail")
+	}
+}
+
+func TestExpire(t *testing.T) {
+	c := New(0, nil)
+	c.AddWithExpire("name", Str("hylio"), time.Now().Add(-time.Second))
+	if _, ok := c.Get("name"); ok || c.Len() != 0 {
+		t.Error("expired entry should miss")
+	}
+}
+
+func TestSketch(t *testing.T) {
+	s := newCMSketch(64)
+	for i := 0; i < 5; i++ {
+		s.Increment("hot")
+	}
+	if e := s.Estimate("hot"); e != 5 {
+		t.Fatalf("expect estimate 5, but got %d", e)
+	}
+	s.reset()
+	if e := s.Estimate("hot"); e != 2 {
+		t.Fatalf("expect estimate 2 after reset, but got %d", e)
+	}
+}
+
+// trace 生成访问序列 热点key服从zipf分布 并混入大量只访问一次的扫描key
+func trace(n int) []string {
+	r := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(r, 1.01, 1, 10000)
+	keys := make([]string, n)
+	for i := range keys {
+		if r.Intn(10) < 3 {
+			keys[i] = fmt.Sprintf("scan%d", i)
+		} else {
+			keys[i] = fmt.Sprintf("hot%d", zipf.Uint64())
+		}
+	}
+	return keys
+}
+
+// replay 回放访问序列 未命中时写入缓存 返回命中率
+func replay(p lru.Policy, keys []string) float64 {
+	hits := 0
+	for _, key := range keys {
+		if _, ok := p.Get(key); ok {
+			hits++
+			continue
+		}
+		p.Add(key, Str("0123456789abcdef"))
+	}
+	return float64(hits) / float64(len(keys))
+}
+
+const traceBytes = 16 << 10
+
+func TestHitRatio(t *testing.T) {
+	keys := trace(200000)
+	lruRatio := replay(lru.New(traceBytes, nil), keys)
+	tinyRatio := replay(New(traceBytes, nil), keys)
+	t.Logf("lru hit ratio %.4f, tinylfu hit ratio %.4f", lruRatio, tinyRatio)
+	if tinyRatio <= lruRatio {
+		t.Fatalf("tinylfu hit ratio %.4f should be higher than lru %.4f", tinyRatio, lruRatio)
+	}
+}
+
+func BenchmarkTraceReplay(b *testing.B) {
+	keys := trace(200000)
+	policies := map[string]lru.NewPolicy{
+		"LRU":     lru.LRU,
+		"ARC":     lru.ARC,
+		"TinyLFU": NewPolicy,
+	}
+	for name, newPolicy := range policies {
+		b.Run(name, func(b *testing.B) {
+			var ratio float64
+			for i := 0; i < b.N; i++ {
+				ratio = replay(newPolicy(traceBytes, nil), keys)
+			}
+			b.ReportMetric(ratio*100, "hit%")
+		})
+	}
+}
+
+func TestCache_Delete(t *testing.T) {
+	c := New(0, nil)
+	c.Add("name", Str("hylio"))
+	if !c.Delete("name") || c.Delete("age") {
+		t.Error("delete fail")
+	}
+	if _, ok := c.Get("name"); ok || c.Len() != 0 || c.Bytes() != 0 {
+		t.Error("name should be deleted")
+	}
+}